@@ -0,0 +1,587 @@
+package newredis
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBitOffset      = errors.New("ERR bit offset is not an integer or out of range")
+	ErrBitValue       = errors.New("ERR bit is not an integer or out of range")
+	ErrBitfieldType   = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	ErrBitfieldRO     = errors.New("ERR BITFIELD_RO only supports the GET subcommand")
+	ErrOverflowType   = errors.New("ERR Invalid OVERFLOW type specified")
+	ErrSyntax         = errors.New("ERR syntax error")
+	ErrBitopNotSource = errors.New("ERR BITOP NOT must be called with a single source key.")
+)
+
+//popcount counts the set bits of p, eight bytes at a time
+func popcount(p []byte) int {
+	count := 0
+	for len(p) >= 32 {
+		count += bits.OnesCount64(binary.LittleEndian.Uint64(p)) +
+			bits.OnesCount64(binary.LittleEndian.Uint64(p[8:])) +
+			bits.OnesCount64(binary.LittleEndian.Uint64(p[16:])) +
+			bits.OnesCount64(binary.LittleEndian.Uint64(p[24:]))
+		p = p[32:]
+	}
+	for len(p) >= 8 {
+		count += bits.OnesCount64(binary.LittleEndian.Uint64(p))
+		p = p[8:]
+	}
+	for _, b := range p {
+		count += bits.OnesCount8(b)
+	}
+	return count
+}
+
+//getBit returns the bit at offset, bit 0 is the most significant bit of the first byte
+func getBit(p []byte, offset int64) int {
+	if offset>>3 >= int64(len(p)) {
+		return 0
+	}
+	return int(p[offset>>3]>>(7-uint(offset&7))) & 1
+}
+
+func setBit(p []byte, offset int64, on bool) {
+	mask := byte(1) << (7 - uint(offset&7))
+	if on {
+		p[offset>>3] |= mask
+	} else {
+		p[offset>>3] &^= mask
+	}
+}
+
+//findBit finds the first bit equal to bit between the bit offsets start and
+//end included, whole words that can't match are skipped
+func findBit(p []byte, bit int, start, end int64) int64 {
+	var skip uint64
+	if bit == 0 {
+		skip = math.MaxUint64
+	}
+	for pos := start; pos <= end; {
+		if pos&63 == 0 && pos+63 <= end && binary.LittleEndian.Uint64(p[pos>>3:]) == skip {
+			pos += 64
+			continue
+		}
+		if pos&7 == 0 && pos+7 <= end && uint64(p[pos>>3]) == skip&0xff {
+			pos += 8
+			continue
+		}
+		if getBit(p, pos) == bit {
+			return pos
+		}
+		pos++
+	}
+	return -1
+}
+
+//normalizeRange turns the start and end arguments of BITCOUNT and friends into
+//offsets between 0 and size-1, ok is false when the range is empty
+func normalizeRange(start, end, size int64) (int64, int64, bool) {
+	if start < 0 {
+		start += size
+	}
+	if end < 0 {
+		end += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	return start, end, start <= end
+}
+
+//checkBitOffset validates a bit offset against the max string size
+func (m *Memdb) checkBitOffset(offset int64) error {
+	if offset < 0 || offset>>3 >= m.s.conf.protoMaxBulkLen {
+		return ErrBitOffset
+	}
+	return nil
+}
+
+//lookupBitmap returns the string at key for writing, created or padded with
+//zero bytes so that the bit at offset exists
+func (m *Memdb) lookupBitmap(key string, offset int64) (*Object, error) {
+	o, err := m.lookupWrite(key, ObjString)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		o = newStringObject(nil)
+		m.setKey(key, o)
+	}
+	o.grow(int(offset>>3) + 1)
+	return o, nil
+}
+
+func (m *Memdb) Setbit(key string, offset int64, on bool) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if err := m.checkBitOffset(offset); err != nil {
+		return 0, err
+	}
+	if _, err := m.lookupWrite(key, ObjString); err != nil {
+		return 0, err
+	}
+	if !m.recovebool {
+		v := []byte("0")
+		if on {
+			v = []byte("1")
+		}
+		err := m.save(&Opt{Method: "setbit", Key: key, Args: [][]byte{[]byte(strconv.FormatInt(offset, 10)), v}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	o, err := m.lookupBitmap(key, offset)
+	if err != nil {
+		return 0, err
+	}
+	old := getBit(o.Str, offset)
+	setBit(o.Str, offset, on)
+	return old, nil
+}
+
+func (m *Memdb) Getbit(key string, offset int64) (int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	if err := m.checkBitOffset(offset); err != nil {
+		return 0, err
+	}
+	o, err := m.lookupRead(key, ObjString)
+	if o == nil {
+		return 0, err
+	}
+	return getBit(o.Str, offset), nil
+}
+
+//Bitcount counts the set bits of the string at key, with hasRange only between
+//start and end, which are bit offsets when isBit is set and byte offsets otherwise
+func (m *Memdb) Bitcount(key string, start, end int64, hasRange, isBit bool) (int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjString)
+	if o == nil {
+		return 0, err
+	}
+	if !hasRange {
+		return popcount(o.Str), nil
+	}
+	if start < 0 && end < 0 && start > end {
+		return 0, nil
+	}
+	size := int64(len(o.Str))
+	if isBit {
+		size *= 8
+	}
+	start, end, ok := normalizeRange(start, end, size)
+	if !ok {
+		return 0, nil
+	}
+	if !isBit {
+		return popcount(o.Str[start : end+1]), nil
+	}
+	//先按字节数，再减掉首尾字节里不在范围内的位
+	count := popcount(o.Str[start>>3 : end>>3+1])
+	count -= bits.OnesCount8(o.Str[start>>3] & ^byte(0xff>>uint(start&7)))
+	count -= bits.OnesCount8(o.Str[end>>3] & byte(0xff>>uint(end&7+1)))
+	return count, nil
+}
+
+//Bitpos returns the position of the first bit set to bit. Without an explicit
+//end the string is considered padded with zeros on the right.
+func (m *Memdb) Bitpos(key string, bit int, start, end int64, hasEnd, isBit bool) (int64, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjString)
+	if err != nil {
+		return 0, err
+	}
+	if o == nil {
+		if bit == 1 {
+			return -1, nil
+		}
+		return 0, nil
+	}
+	size := int64(len(o.Str))
+	if isBit {
+		size *= 8
+	}
+	if !hasEnd {
+		end = size - 1
+	}
+	start, end, ok := normalizeRange(start, end, size)
+	if !ok {
+		return -1, nil
+	}
+	if !isBit {
+		start, end = start*8, end*8+7
+	}
+	pos := findBit(o.Str, bit, start, end)
+	if pos == -1 && bit == 0 && !hasEnd {
+		return end + 1, nil
+	}
+	return pos, nil
+}
+
+//Bitop stores the result of the bitwise operation op between the strings at
+//keys into dest and returns its length, an empty result deletes dest
+func (m *Memdb) Bitop(op string, dest string, keys ...[]byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if op != "and" && op != "or" && op != "xor" && op != "not" {
+		return 0, ErrSyntax
+	}
+	if op == "not" && len(keys) != 1 {
+		return 0, ErrBitopNotSource
+	}
+	srcs := make([][]byte, len(keys))
+	maxlen := 0
+	for i, k := range keys {
+		o, err := m.lookupWrite(string(k), ObjString)
+		if err != nil {
+			return 0, err
+		}
+		if o != nil {
+			srcs[i] = o.Str
+		}
+		if len(srcs[i]) > maxlen {
+			maxlen = len(srcs[i])
+		}
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "bitop", Key: dest, Args: append([][]byte{[]byte(op)}, keys...)})
+		if err != nil {
+			return 0, err
+		}
+	}
+	m.expireIfNeeded(dest)
+	if maxlen == 0 {
+		m.delKey(dest)
+		return 0, nil
+	}
+	res := make([]byte, maxlen)
+	copy(res, srcs[0])
+	switch op {
+	case "not":
+		for i := range res {
+			res[i] = ^res[i]
+		}
+	case "and":
+		for _, src := range srcs[1:] {
+			for i := range res {
+				if i < len(src) {
+					res[i] &= src[i]
+				} else {
+					res[i] = 0
+				}
+			}
+		}
+	case "or":
+		for _, src := range srcs[1:] {
+			for i := 0; i < len(src); i++ {
+				res[i] |= src[i]
+			}
+		}
+	case "xor":
+		for _, src := range srcs[1:] {
+			for i := 0; i < len(src); i++ {
+				res[i] ^= src[i]
+			}
+		}
+	}
+	m.setKey(dest, newStringObject(res))
+	delete(m.Expires, dest)
+	return maxlen, nil
+}
+
+const (
+	bitfieldGet = iota
+	bitfieldSet
+	bitfieldIncrBy
+)
+
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+type bitfieldOp struct {
+	opcode   int
+	offset   int64
+	bits     uint
+	signed   bool
+	value    int64
+	overflow int
+}
+
+//parseBitfieldArgs parses the subcommands of BITFIELD, readonly only allows GET
+func (m *Memdb) parseBitfieldArgs(args [][]byte, readonly bool) ([]bitfieldOp, error) {
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	for i := 0; i < len(args); i++ {
+		sub := strings.ToLower(string(args[i]))
+		if sub == "overflow" {
+			if i+1 >= len(args) {
+				return nil, ErrSyntax
+			}
+			i++
+			switch strings.ToLower(string(args[i])) {
+			case "wrap":
+				overflow = overflowWrap
+			case "sat":
+				overflow = overflowSat
+			case "fail":
+				overflow = overflowFail
+			default:
+				return nil, ErrOverflowType
+			}
+			continue
+		}
+		op := bitfieldOp{overflow: overflow}
+		argc := 2
+		switch sub {
+		case "get":
+			op.opcode = bitfieldGet
+		case "set":
+			op.opcode = bitfieldSet
+			argc = 3
+		case "incrby":
+			op.opcode = bitfieldIncrBy
+			argc = 3
+		default:
+			return nil, ErrSyntax
+		}
+		if i+argc >= len(args) {
+			return nil, ErrSyntax
+		}
+		if readonly && op.opcode != bitfieldGet {
+			return nil, ErrBitfieldRO
+		}
+		//类型是i1..i64或者u1..u63
+		typ := args[i+1]
+		if len(typ) < 2 || (typ[0] != 'i' && typ[0] != 'I' && typ[0] != 'u' && typ[0] != 'U') {
+			return nil, ErrBitfieldType
+		}
+		op.signed = typ[0] == 'i' || typ[0] == 'I'
+		n, ok := string2ll(typ[1:])
+		if !ok || n < 1 || (op.signed && n > 64) || (!op.signed && n > 63) {
+			return nil, ErrBitfieldType
+		}
+		op.bits = uint(n)
+		//offset前面加#表示按类型的宽度计算
+		offset := args[i+2]
+		mul := int64(1)
+		if len(offset) > 0 && offset[0] == '#' {
+			mul = int64(op.bits)
+			offset = offset[1:]
+		}
+		op.offset, ok = string2ll(offset)
+		if !ok || op.offset < 0 || op.offset > math.MaxInt64/mul {
+			return nil, ErrBitOffset
+		}
+		op.offset *= mul
+		if err := m.checkBitOffset(op.offset + int64(op.bits) - 1); err != nil {
+			return nil, err
+		}
+		if argc == 3 {
+			if op.value, ok = string2ll(args[i+3]); !ok {
+				return nil, ErrNotInteger
+			}
+		}
+		ops = append(ops, op)
+		i += argc
+	}
+	return ops, nil
+}
+
+func getUnsignedBitfield(p []byte, offset int64, n uint) uint64 {
+	var v uint64
+	for j := int64(0); j < int64(n); j++ {
+		v = v<<1 | uint64(getBit(p, offset+j))
+	}
+	return v
+}
+
+func getSignedBitfield(p []byte, offset int64, n uint) int64 {
+	v := int64(getUnsignedBitfield(p, offset, n))
+	if n < 64 && v&(1<<(n-1)) != 0 {
+		v |= -1 << n
+	}
+	return v
+}
+
+func setBitfield(p []byte, offset int64, n uint, v uint64) {
+	for j := uint(0); j < n; j++ {
+		setBit(p, offset+int64(j), v&(1<<(n-1-j)) != 0)
+	}
+}
+
+//checkUnsignedBitfieldOverflow returns value+incr handled according to
+//overflow, ok is false when the operation must fail
+func checkUnsignedBitfieldOverflow(value uint64, incr int64, n uint, overflow int) (uint64, bool) {
+	max := uint64(1)<<n - 1
+	var over, under bool
+	if value > max || (incr > 0 && uint64(incr) > max-value) {
+		over = true
+	} else if incr < 0 && uint64(-incr) > value {
+		under = true
+	}
+	if !over && !under {
+		return value + uint64(incr), true
+	}
+	switch overflow {
+	case overflowWrap:
+		return (value + uint64(incr)) & max, true
+	case overflowSat:
+		if over {
+			return max, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func checkSignedBitfieldOverflow(value, incr int64, n uint, overflow int) (int64, bool) {
+	max := int64(math.MaxInt64)
+	if n < 64 {
+		max = 1<<(n-1) - 1
+	}
+	min := -max - 1
+	var over, under bool
+	switch {
+	case value > max || (incr > 0 && value > max-incr):
+		over = true
+	case value < min || (incr < 0 && value < min-incr):
+		under = true
+	}
+	if !over && !under {
+		return value + incr, true
+	}
+	switch overflow {
+	case overflowWrap:
+		//截断到n位再做符号扩展
+		c := uint64(value) + uint64(incr)
+		if n < 64 {
+			if c&(1<<(n-1)) != 0 {
+				c |= math.MaxUint64 << n
+			} else {
+				c &^= math.MaxUint64 << n
+			}
+		}
+		return int64(c), true
+	case overflowSat:
+		if over {
+			return max, true
+		}
+		return min, true
+	}
+	return 0, false
+}
+
+//Bitfield runs the BITFIELD subcommands in args against the string at key, a
+//nil result means the operation failed because of OVERFLOW FAIL
+func (m *Memdb) Bitfield(key string, args [][]byte, readonly bool) ([]*int64, error) {
+	ops, err := m.parseBitfieldArgs(args, readonly)
+	if err != nil {
+		return nil, err
+	}
+	var write bool
+	var maxOffset int64
+	for _, op := range ops {
+		if op.opcode != bitfieldGet {
+			write = true
+			if end := op.offset + int64(op.bits) - 1; end > maxOffset {
+				maxOffset = end
+			}
+		}
+	}
+	if !write {
+		m.rwmu.RLock()
+		defer m.rwmu.RUnlock()
+	} else {
+		m.rwmu.Lock()
+		defer m.rwmu.Unlock()
+	}
+	var p []byte
+	if write {
+		if _, err := m.lookupWrite(key, ObjString); err != nil {
+			return nil, err
+		}
+		//参数确定了结果，wal直接记录原始参数
+		if !m.recovebool {
+			err := m.save(&Opt{Method: "bitfield", Key: key, Args: args})
+			if err != nil {
+				return nil, err
+			}
+		}
+		o, err := m.lookupBitmap(key, maxOffset)
+		if err != nil {
+			return nil, err
+		}
+		p = o.Str
+	} else {
+		o, err := m.lookupRead(key, ObjString)
+		if err != nil {
+			return nil, err
+		}
+		if o != nil {
+			p = o.Str
+		}
+	}
+	ret := make([]*int64, len(ops))
+	for i, op := range ops {
+		var v int64
+		ok := true
+		if op.signed {
+			old := getSignedBitfield(p, op.offset, op.bits)
+			switch op.opcode {
+			case bitfieldGet:
+				v = old
+			case bitfieldSet:
+				var nv int64
+				if nv, ok = checkSignedBitfieldOverflow(op.value, 0, op.bits, op.overflow); ok {
+					setBitfield(p, op.offset, op.bits, uint64(nv))
+				}
+				v = old
+			case bitfieldIncrBy:
+				if v, ok = checkSignedBitfieldOverflow(old, op.value, op.bits, op.overflow); ok {
+					setBitfield(p, op.offset, op.bits, uint64(v))
+				}
+			}
+		} else {
+			old := getUnsignedBitfield(p, op.offset, op.bits)
+			switch op.opcode {
+			case bitfieldGet:
+				v = int64(old)
+			case bitfieldSet:
+				var nv uint64
+				if nv, ok = checkUnsignedBitfieldOverflow(uint64(op.value), 0, op.bits, op.overflow); ok {
+					setBitfield(p, op.offset, op.bits, nv)
+				}
+				v = int64(old)
+			case bitfieldIncrBy:
+				var nv uint64
+				if nv, ok = checkUnsignedBitfieldOverflow(old, op.value, op.bits, op.overflow); ok {
+					setBitfield(p, op.offset, op.bits, nv)
+				}
+				v = int64(nv)
+			}
+		}
+		if ok {
+			ret[i] = &v
+		}
+	}
+	return ret, nil
+}
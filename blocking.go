@@ -0,0 +1,200 @@
+package newredis
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTimeoutNegative = errors.New("ERR timeout is negative")
+	ErrTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
+)
+
+//blockedClient is a client waiting in BLPOP, BLMPOP or BLMOVE for one of its
+//keys to hold a list. It is queued on every key and served by the first push.
+type blockedClient struct {
+	keys    []string
+	left    bool
+	count   int
+	move    bool //BLMOVE, the element goes to dst
+	dst     string
+	dstLeft bool
+	done    bool //served or given up, protected by the db lock
+	reply   chan blockedReply
+}
+
+type blockedReply struct {
+	key  string
+	vals [][]byte
+	err  error
+}
+
+//parseTimeout parses the timeout of blocking commands, in seconds with
+//decimals. 0 means block forever.
+func parseTimeout(arg []byte) (time.Duration, error) {
+	f, ok := string2ld(arg)
+	if !ok {
+		return 0, ErrTimeoutNotFloat
+	}
+	if f.Sign() < 0 {
+		return 0, ErrTimeoutNegative
+	}
+	sec, _ := f.Float64()
+	if sec*1000 > float64(1<<62/time.Millisecond) {
+		return 0, ErrTimeoutNotFloat
+	}
+	return time.Duration(sec*1000) * time.Millisecond, nil
+}
+
+//block queues a client on keys, the caller must hold the write lock
+func (m *Memdb) block(w *blockedClient) *blockedClient {
+	w.reply = make(chan blockedReply, 1)
+	for _, key := range w.keys {
+		m.blocked[key] = append(m.blocked[key], w)
+	}
+	return w
+}
+
+//unblock removes w from the queues of all its keys, the caller must hold the write lock
+func (m *Memdb) unblock(w *blockedClient) {
+	w.done = true
+	for _, key := range w.keys {
+		queue := m.blocked[key]
+		for i, c := range queue {
+			if c == w {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(m.blocked, key)
+		} else {
+			m.blocked[key] = queue
+		}
+	}
+}
+
+//cancel gives up waiting for w, if it has been served in the meantime the
+//reply is returned anyway
+func (m *Memdb) cancel(w *blockedClient) (blockedReply, bool) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if w.done {
+		return <-w.reply, true
+	}
+	m.unblock(w)
+	return blockedReply{}, false
+}
+
+//serveBlocked hands the list at key to the clients blocked on it in FIFO
+//order, until the list is empty. A BLMOVE makes its destination ready in turn.
+//The caller must hold the write lock.
+func (m *Memdb) serveBlocked(key string) {
+	ready := []string{key}
+	for len(ready) > 0 {
+		key := ready[0]
+		ready = ready[1:]
+		for len(m.blocked[key]) > 0 {
+			o, err := m.lookupWrite(key, ObjList)
+			if o == nil || err != nil {
+				//被删除或者变成了别的类型，继续等
+				break
+			}
+			w := m.blocked[key][0]
+			m.unblock(w)
+			r := blockedReply{key: key}
+			if w.move {
+				var v []byte
+				if v, r.err = m.lmove(key, w.dst, w.left, w.dstLeft); v != nil {
+					r.vals = [][]byte{v}
+					ready = append(ready, w.dst)
+				}
+			} else {
+				r.vals, r.err = m.pop(key, o, w.left, w.count)
+			}
+			w.reply <- r
+		}
+	}
+}
+
+//serveAllBlocked serves the clients blocked on any key of the database, after
+//the whole content has changed
+func (m *Memdb) serveAllBlocked() {
+	for key := range m.blocked {
+		m.serveBlocked(key)
+	}
+}
+
+//Blmpop is Lmpop that blocks: when all the lists are empty it returns a
+//client queued on keys to wait on
+func (m *Memdb) Blmpop(keys []string, left bool, count int) (string, [][]byte, *blockedClient, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	for _, key := range keys {
+		o, err := m.lookupWrite(key, ObjList)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if o != nil {
+			vals, err := m.pop(key, o, left, count)
+			return key, vals, nil, err
+		}
+	}
+	return "", nil, m.block(&blockedClient{keys: keys, left: left, count: count}), nil
+}
+
+//Blmove is Lmove that blocks when src is empty
+func (m *Memdb) Blmove(src, dst string, srcLeft, dstLeft bool) ([]byte, *blockedClient, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	v, err := m.lmove(src, dst, srcLeft, dstLeft)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v != nil {
+		m.serveBlocked(dst)
+		return v, nil, nil
+	}
+	w := &blockedClient{keys: []string{src}, left: srcLeft, move: true, dst: dst, dstLeft: dstLeft}
+	return nil, m.block(w), nil
+}
+
+//waitBlocked parks the connection until w is served, the timeout expires or
+//the client goes away. ok is false when w has not been served.
+func (s *Server) waitBlocked(c Conn, db *Memdb, w *blockedClient, timeout time.Duration) (r blockedReply, ok bool) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	closed := make(chan struct{})
+	if cn, isConn := c.(*conn); isConn {
+		//在后台Peek连接，客户端断开的时候不再等待。Peek读到的数据留在
+		//bufio里，之后照常解析；返回前用读超时让Peek结束
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cn.rd.rd.Peek(1); err != nil {
+				if ne, isNet := err.(net.Error); !isNet || !ne.Timeout() {
+					close(closed)
+				}
+			}
+		}()
+		defer func() {
+			cn.conn.SetReadDeadline(time.Now())
+			wg.Wait()
+			cn.conn.SetReadDeadline(time.Time{})
+		}()
+	}
+	select {
+	case r = <-w.reply:
+		return r, true
+	case <-expired:
+	case <-closed:
+	}
+	return db.cancel(w)
+}
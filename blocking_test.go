@@ -0,0 +1,136 @@
+package newredis
+
+import (
+	"testing"
+	"time"
+)
+
+//blockedClients returns the number of clients waiting on db
+func blockedClients(db *Memdb) int {
+	db.rwmu.RLock()
+	defer db.rwmu.RUnlock()
+	clients := make(map[*blockedClient]struct{})
+	for _, queue := range db.blocked {
+		for _, w := range queue {
+			clients[w] = struct{}{}
+		}
+	}
+	return len(clients)
+}
+
+//startBlocked runs line in the background and waits until it is blocked, so
+//that clients are queued in the order they are started
+func startBlocked(t *testing.T, s *Server, line string) <-chan string {
+	t.Helper()
+	n := blockedClients(s.dbs[0])
+	reply := make(chan string, 1)
+	go func() {
+		reply <- do(s, &testConn{}, line)
+	}()
+	for deadline := time.Now().Add(time.Second); blockedClients(s.dbs[0]) == n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: not blocked", line)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return reply
+}
+
+func TestBlockingWakeup(t *testing.T) {
+	tests := []struct {
+		name    string
+		blocked []string //started in order, each of them must block
+		cmds    []cmdCase
+		want    []string //replies of the blocked clients
+	}{
+		{
+			"fifo",
+			[]string{"blpop k 0", "brpop k 0", "blpop k 0"},
+			[]cmdCase{{"rpush k a b c d", ":4"}, {"lrange k 0 -1", "*1 $c"}},
+			[]string{"*2 $k $a", "*2 $k $d", "*2 $k $b"},
+		},
+		{
+			"one element per push",
+			[]string{"blpop k 0", "blpop k 0"},
+			[]cmdCase{{"lpush k a", ":1"}, {"exists k", ":0"}, {"lpush k b", ":1"}},
+			[]string{"*2 $k $a", "*2 $k $b"},
+		},
+		{
+			"first ready key",
+			[]string{"blpop k1 k2 k3 0", "blpop k3 0"},
+			[]cmdCase{{"rpush k3 a b", ":2"}},
+			[]string{"*2 $k3 $a", "*2 $k3 $b"},
+		},
+		{
+			"client served once",
+			[]string{"blpop k1 k2 0", "blpop k2 0"},
+			[]cmdCase{{"rpush k1 a", ":1"}, {"rpush k2 b", ":1"}},
+			[]string{"*2 $k1 $a", "*2 $k2 $b"},
+		},
+		{
+			"blmpop count",
+			[]string{"blmpop 0 2 k1 k2 right count 2", "blmpop 0 1 k2 left count 5"},
+			[]cmdCase{{"rpush k2 a b c", ":3"}},
+			[]string{"*2 $k2 *2 $c $b", "*2 $k2 *1 $a"},
+		},
+		{
+			"blmove chain",
+			[]string{"blmove a b right left 0", "brpoplpush b c 0", "blpop c 0"},
+			[]cmdCase{{"rpush a x", ":1"}, {"dbsize", ":0"}},
+			[]string{"$x", "$x", "*2 $c $x"},
+		},
+		{
+			"wrong type keeps waiting",
+			[]string{"blpop k 0"},
+			[]cmdCase{{"set k v", "+OK"}, {"del k", ":1"}, {"rpush k a", ":1"}},
+			[]string{"*2 $k $a"},
+		},
+		{
+			"timeout",
+			[]string{"blpop k 0.05", "blmpop 0.05 1 k left", "blmove k d left left 0.05"},
+			nil,
+			[]string{"(nil)", "(nil)", "(nil)"},
+		},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		var replies []<-chan string
+		for _, line := range tt.blocked {
+			replies = append(replies, startBlocked(t, s, line))
+		}
+		runCases(t, s, tt.cmds)
+		for i, reply := range replies {
+			select {
+			case got := <-reply:
+				if got != tt.want[i] {
+					t.Errorf("%s: %s got %q, want %q", tt.name, tt.blocked[i], got, tt.want[i])
+				}
+			case <-time.After(2 * time.Second):
+				t.Errorf("%s: %s still blocked", tt.name, tt.blocked[i])
+			}
+		}
+		if n := blockedClients(s.dbs[0]); n != 0 {
+			t.Errorf("%s: %d clients left in the queues", tt.name, n)
+		}
+		assertSameKeyspace(t, s, replay(t))
+	}
+}
+
+func TestBlockingCommands(t *testing.T) {
+	runCases(t, newTestServer(), []cmdCase{
+		{"rpush k a b c", ":3"},
+		{"blpop nokey k 0", "*2 $k $a"},
+		{"brpop k 1", "*2 $k $c"},
+		{"blmpop 0 1 k left count 3", "*2 $k *1 $b"},
+		{"blpop k -1", "ERR timeout is negative"},
+		{"blpop k x", "ERR timeout is not a float or out of range"},
+		{"blmpop 0 0 k left", "ERR numkeys should be greater than 0"},
+		{"blmpop 0 2 k left", "ERR syntax error"},
+		{"blmpop 0 9223372036854775807 k left", "ERR syntax error"},
+		{"blmpop 0 9223372036854775805 k left", "ERR syntax error"},
+		{"blmpop 0 1 k up", "ERR syntax error"},
+		{"blmpop 0 1 k left count 0", "ERR count should be greater than 0"},
+		{"set s v", "+OK"},
+		{"blpop s 0", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+}
@@ -19,6 +19,9 @@ func main() {
 	d := flag.String("data", "data/", "dir to save wal and snapshot")
 	p := flag.Int("p", 6380, "port for net listen")
 	P := flag.Bool("P", false, "profiling this program")
+	n := flag.Int("databases", 16, "number of databases")
+	m := flag.Int64("proto-max-bulk-len", 512*1024*1024, "max size of a string value")
+	e := flag.Int("set-max-intset-entries", 512, "max size of a set using the intset encoding")
 	flag.Parse()
 
 	if flag.Arg(0) == "version" {
@@ -38,7 +41,7 @@ func main() {
 		}
 	}
 
-	c := newredis.DefaultConfig().SnapCount(*count).OpenWal(*w).Laddr(fmt.Sprintf(":%d", *p)).DataDir(dirpath).Sync(*s)
+	c := newredis.DefaultConfig().SnapCount(*count).OpenWal(*w).Laddr(fmt.Sprintf(":%d", *p)).DataDir(dirpath).Sync(*s).Databases(*n).ProtoMaxBulkLen(*m).SetMaxIntsetEntries(*e)
 	go log.Printf("started server at %s wal model %s", c.Gaddr(), c.Gwalsavetype())
 	err = newredis.ListenAndServe(c,
 		func(conn newredis.Conn) bool {
@@ -3,6 +3,9 @@ package newredis
 import (
 	"strings"
 	"strconv"
+	"math"
+	"errors"
+	"time"
 )
 
 type fn func(s *Server, conn Conn, cmd Command) error
@@ -29,28 +32,55 @@ func ping(s *Server, conn Conn, cmd Command) error {
 }
 
 func sselect(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	index, err := strconv.Atoi(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError("ERR value is not an integer or out of range")
+		return nil
+	}
+	if _, err := s.database(index); err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.SelectDB(index)
 	conn.WriteString("OK")
 	return nil
 }
 
-//string opt
-func set(s *Server, conn Conn, cmd Command) error {
+func move(s *Server, conn Conn, cmd Command) error {
 	if len(cmd.Args) != 3 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	s.db.Set(string(cmd.Args[1]), cmd.Args[2])
-	conn.WriteString("OK")
+	index, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError("ERR value is not an integer or out of range")
+		return nil
+	}
+	num, err := s.Move(s.selectedDB(conn), string(cmd.Args[1]), index)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
 	return nil
 }
 
-func mset(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) < 3 {
+func swapdb(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	err := s.db.Mset(cmd.Args[1:]...)
-	if err != nil {
+	a, err1 := strconv.Atoi(string(cmd.Args[1]))
+	b, err2 := strconv.Atoi(string(cmd.Args[2]))
+	if err1 != nil || err2 != nil {
+		conn.WriteError("ERR invalid DB index")
+		return nil
+	}
+	if err := s.SwapDB(a, b); err != nil {
 		conn.WriteError(err.Error())
 		return nil
 	}
@@ -58,290 +88,2695 @@ func mset(s *Server, conn Conn, cmd Command) error {
 	return nil
 }
 
-func del(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) < 2 {
-		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
-		return nil
+//parseFlushArgs parses the optional ASYNC|SYNC argument of FLUSHDB and FLUSHALL
+func parseFlushArgs(cmd Command) (bool, error) {
+	if len(cmd.Args) > 2 {
+		return false, errors.New("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+	}
+	if len(cmd.Args) == 1 {
+		return false, nil
+	}
+	switch strings.ToLower(string(cmd.Args[1])) {
+	case "async":
+		return true, nil
+	case "sync":
+		return false, nil
+	}
+	return false, errors.New("ERR syntax error")
+}
+
+func flushdb(s *Server, conn Conn, cmd Command) error {
+	async, err := parseFlushArgs(cmd)
+	if err == nil {
+		err = s.selectedDB(conn).FlushDB(async)
 	}
-	num, err := s.db.Del(cmd.Args...)
 	if err != nil {
 		conn.WriteError(err.Error())
 		return nil
 	}
-	conn.WriteInt(num)
+	conn.WriteString("OK")
 	return nil
 }
 
-func incr(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 2 {
-		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
-		return nil
+func flushall(s *Server, conn Conn, cmd Command) error {
+	async, err := parseFlushArgs(cmd)
+	if err == nil {
+		err = s.FlushAll(async)
 	}
-	num, err := s.db.Incr(string(cmd.Args[1]))
 	if err != nil {
 		conn.WriteError(err.Error())
 		return nil
 	}
-	conn.WriteInt(num)
+	conn.WriteString("OK")
 	return nil
 }
 
-func get(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 2 {
+//string opt
+func set(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, err := s.db.Get(string(cmd.Args[1]))
+	flags, when, err := parseSetArgs(cmd.Args[3:], mstime())
 	if err != nil {
 		conn.WriteError(err.Error())
 		return nil
 	}
-	if v == nil {
+	old, ok, err := s.selectedDB(conn).SetGeneric(string(cmd.Args[1]), cmd.Args[2], flags, when)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if flags&setGet != 0 {
+		if old == nil {
+			conn.WriteNull()
+		} else {
+			conn.WriteBulk(old)
+		}
+	} else if !ok {
 		conn.WriteNull()
 	} else {
-		conn.WriteBulk(v)
+		conn.WriteString("OK")
 	}
-
 	return nil
 }
 
-//list opt
-func lpush(s *Server, conn Conn, cmd Command) error {
+//parseSetArgs parses the SET options [NX|XX] [GET] [EX|PX|EXAT|PXAT time|KEEPTTL]
+//and returns the flags and the absolute deadline in unix milliseconds
+func parseSetArgs(args [][]byte, now int64) (int, int64, error) {
+	flags := 0
+	var when int64
+	expireSet := false
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		switch opt {
+		case "nx":
+			if flags&setXX != 0 {
+				return 0, 0, errors.New("ERR syntax error")
+			}
+			flags |= setNX
+		case "xx":
+			if flags&setNX != 0 {
+				return 0, 0, errors.New("ERR syntax error")
+			}
+			flags |= setXX
+		case "get":
+			flags |= setGet
+		case "keepttl":
+			if expireSet {
+				return 0, 0, errors.New("ERR syntax error")
+			}
+			flags |= setKeepTTL
+		case "ex", "px", "exat", "pxat":
+			if expireSet || flags&setKeepTTL != 0 || i+1 >= len(args) {
+				return 0, 0, errors.New("ERR syntax error")
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return 0, 0, errors.New("ERR value is not an integer or out of range")
+			}
+			var base, unit int64
+			switch opt {
+			case "ex":
+				base, unit = now, 1000
+			case "px":
+				base, unit = now, 1
+			case "exat":
+				base, unit = 0, 1000
+			case "pxat":
+				base, unit = 0, 1
+			}
+			if n <= 0 || n > (math.MaxInt64-base)/unit {
+				return 0, 0, errors.New("ERR invalid expire time in 'set' command")
+			}
+			when = base + n*unit
+			expireSet = true
+		default:
+			return 0, 0, errors.New("ERR syntax error")
+		}
+	}
+	return flags, when, nil
+}
+
+func mset(s *Server, conn Conn, cmd Command) error {
 	if len(cmd.Args) < 3 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, err := s.db.Lpush(cmd.Args[1:]...)
+	err := s.selectedDB(conn).Mset(cmd.Args[1:]...)
 	if err != nil {
 		conn.WriteError(err.Error())
 		return nil
 	}
-	conn.WriteInt(v)
+	conn.WriteString("OK")
 	return nil
 }
 
-func rpush(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) < 3 {
+func del(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, err := s.db.Rpush(cmd.Args[1:]...)
+	num, err := s.selectedDB(conn).Del(cmd.Args[1:]...)
 	if err != nil {
 		conn.WriteError(err.Error())
 		return nil
 	}
-	conn.WriteInt(v)
+	conn.WriteInt(num)
 	return nil
 }
 
-func lpop(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 2 {
+func unlink(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, _ := s.db.Lpop(string(cmd.Args[1]))
-	if v == nil {
-		conn.WriteNull()
-	} else {
-		conn.WriteBulk(v)
+	num, err := s.selectedDB(conn).Unlink(cmd.Args[1:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
 	}
-
+	conn.WriteInt(num)
 	return nil
 }
 
-func rpop(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 2 {
+func rename(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, _ := s.db.Rpop(string(cmd.Args[1]))
-	if v == nil {
-		conn.WriteNull()
-	} else {
-		conn.WriteBulk(v)
+	_, err := s.selectedDB(conn).Rename(string(cmd.Args[1]), string(cmd.Args[2]), false)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
 	}
+	conn.WriteString("OK")
 	return nil
 }
 
-func lrange(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 4 {
+func renamenx(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	start, err1 := strconv.Atoi(string(cmd.Args[2]))
-	end, err2 := strconv.Atoi(string(cmd.Args[3]))
-	if err1 != nil || err2 != nil {
+	num, err := s.selectedDB(conn).Rename(string(cmd.Args[1]), string(cmd.Args[2]), true)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
+	return nil
+}
+
+func copyCmd(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, _ := s.db.Lrange(string(cmd.Args[1]), start, end)
-	if v == nil {
-		conn.WriteNull()
-	} else {
-		conn.WriteArray(len(*v))
-		for _, val := range *v {
-			conn.WriteBulk(val)
+	index := conn.SelectedDB()
+	replace := false
+	for i := 3; i < len(cmd.Args); i++ {
+		switch strings.ToLower(string(cmd.Args[i])) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= len(cmd.Args) {
+				conn.WriteError("ERR syntax error")
+				return nil
+			}
+			i++
+			n, err := strconv.Atoi(string(cmd.Args[i]))
+			if err != nil {
+				conn.WriteError("ERR value is not an integer or out of range")
+				return nil
+			}
+			index = n
+		default:
+			conn.WriteError("ERR syntax error")
+			return nil
 		}
 	}
+	num, err := s.Copy(s.selectedDB(conn), string(cmd.Args[1]), string(cmd.Args[2]), index, replace)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
 	return nil
 }
 
-//set opt
-func sadd(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) < 3 {
+func setbit(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	num, err := s.db.Sadd(string(cmd.Args[1]), cmd.Args[2:]...)
+	offset, ok := string2ll(cmd.Args[2])
+	if !ok {
+		conn.WriteError(ErrBitOffset.Error())
+		return nil
+	}
+	v := string(cmd.Args[3])
+	if v != "0" && v != "1" {
+		conn.WriteError(ErrBitValue.Error())
+		return nil
+	}
+	old, err := s.selectedDB(conn).Setbit(string(cmd.Args[1]), offset, v == "1")
 	if err != nil {
 		conn.WriteError(err.Error())
-	} else {
-		conn.WriteInt(num)
+		return nil
 	}
+	conn.WriteInt(old)
 	return nil
 }
 
-func spop(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 2 {
+func getbit(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, _ := s.db.Spop(string(cmd.Args[1]))
-	if v == nil {
-		conn.WriteNull()
-	} else {
-		conn.WriteBulk(v)
+	offset, ok := string2ll(cmd.Args[2])
+	if !ok {
+		conn.WriteError(ErrBitOffset.Error())
+		return nil
+	}
+	bit, err := s.selectedDB(conn).Getbit(string(cmd.Args[1]), offset)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
 	}
+	conn.WriteInt(bit)
 	return nil
 }
 
-func smembers(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 2 {
+//parseBitRange parses the optional [start [end [BYTE|BIT]]] arguments of
+//BITCOUNT and BITPOS, n is how many of start and end were given
+func parseBitRange(args [][]byte) (start, end int64, n int, isBit bool, err error) {
+	if len(args) > 3 {
+		return 0, 0, 0, false, ErrSyntax
+	}
+	if len(args) == 3 {
+		switch strings.ToLower(string(args[2])) {
+		case "bit":
+			isBit = true
+		case "byte":
+		default:
+			return 0, 0, 0, false, ErrSyntax
+		}
+		args = args[:2]
+	}
+	var ok bool
+	for i, arg := range args {
+		var v int64
+		if v, ok = string2ll(arg); !ok {
+			return 0, 0, 0, false, ErrNotInteger
+		}
+		if i == 0 {
+			start = v
+		} else {
+			end = v
+		}
+	}
+	return start, end, len(args), isBit, nil
+}
+
+func bitcount(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, _ := s.db.Smembers(string(cmd.Args[1]))
-	if v == nil {
-		conn.WriteNull()
-	} else {
-		conn.WriteArray(len(v))
-		for _, val := range v {
-			conn.WriteBulk(val)
-		}
+	start, end, n, isBit, err := parseBitRange(cmd.Args[2:])
+	if err == nil && n == 1 {
+		err = ErrSyntax
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	count, err := s.selectedDB(conn).Bitcount(string(cmd.Args[1]), start, end, n == 2, isBit)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
 	}
+	conn.WriteInt(count)
 	return nil
 }
 
-//hash opt
-func hset(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 4 {
+func bitpos(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	num, err := s.db.Hset(string(cmd.Args[1]), string(cmd.Args[2]), cmd.Args[3])
+	bit := string(cmd.Args[2])
+	if bit != "0" && bit != "1" {
+		conn.WriteError("ERR The bit argument must be 1 or 0.")
+		return nil
+	}
+	start, end, n, isBit, err := parseBitRange(cmd.Args[3:])
 	if err != nil {
 		conn.WriteError(err.Error())
-	} else {
-		conn.WriteInt(num)
+		return nil
+	}
+	pos, err := s.selectedDB(conn).Bitpos(string(cmd.Args[1]), int(bit[0]-'0'), start, end, n == 2, isBit)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
 	}
+	conn.WriteInt64(pos)
 	return nil
 }
-func hget(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 3 {
+
+func bitop(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 4 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, err := s.db.Hget(string(cmd.Args[1]), string(cmd.Args[2]))
+	n, err := s.selectedDB(conn).Bitop(strings.ToLower(string(cmd.Args[1])), string(cmd.Args[2]), cmd.Args[3:]...)
 	if err != nil {
 		conn.WriteError(err.Error())
-	} else {
-		conn.WriteBulk(v)
+		return nil
 	}
+	conn.WriteInt(n)
 	return nil
 }
-func hgetall(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 2 {
+
+func bitfieldGeneric(s *Server, conn Conn, cmd Command, readonly bool) error {
+	if len(cmd.Args) < 2 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, err := s.db.Hgetall(string(cmd.Args[1]))
+	ret, err := s.selectedDB(conn).Bitfield(string(cmd.Args[1]), cmd.Args[2:], readonly)
 	if err != nil {
 		conn.WriteError(err.Error())
-	} else {
-		conn.WriteArray(len(v) * 2)
-		for key, val := range v {
-			conn.WriteBulkString(key)
-			conn.WriteBulk(val)
+		return nil
+	}
+	conn.WriteArray(len(ret))
+	for _, v := range ret {
+		if v == nil {
+			conn.WriteNull()
+		} else {
+			conn.WriteInt64(*v)
 		}
 	}
 	return nil
 }
 
-//sort set
-func zadd(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) != 4 {
+func bitfield(s *Server, conn Conn, cmd Command) error {
+	return bitfieldGeneric(s, conn, cmd, false)
+}
+
+func bitfieldRO(s *Server, conn Conn, cmd Command) error {
+	return bitfieldGeneric(s, conn, cmd, true)
+}
+
+func pfadd(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	score, err := strconv.ParseFloat(string(cmd.Args[2]), 64)
+	n, err := s.selectedDB(conn).Pfadd(string(cmd.Args[1]), cmd.Args[2:]...)
 	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(n)
+	return nil
+}
+
+func pfcount(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	num, err := s.db.Zadd(string(cmd.Args[1]), score, string(cmd.Args[3]))
+	keys := make([]string, len(cmd.Args)-1)
+	for i, k := range cmd.Args[1:] {
+		keys[i] = string(k)
+	}
+	card, err := s.selectedDB(conn).Pfcount(keys...)
 	if err != nil {
 		conn.WriteError(err.Error())
-	} else {
-		conn.WriteInt(num)
+		return nil
 	}
+	conn.WriteInt64(int64(card))
 	return nil
 }
-func zrange(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) < 4 {
+
+func pfmerge(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	start, err1 := strconv.Atoi(string(cmd.Args[2]))
-	end, err2 := strconv.Atoi(string(cmd.Args[3]))
-	if err1 != nil || err2 != nil {
-		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+	keys := make([]string, len(cmd.Args)-2)
+	for i, k := range cmd.Args[2:] {
+		keys[i] = string(k)
+	}
+	if err := s.selectedDB(conn).Pfmerge(string(cmd.Args[1]), keys...); err != nil {
+		conn.WriteError(err.Error())
 		return nil
 	}
+	conn.WriteString("OK")
+	return nil
+}
 
-	v, err := s.db.Zrange(string(cmd.Args[1]), start, end, cmd.Args[4:]...)
+func incr(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Incr(string(cmd.Args[1]))
 	if err != nil {
 		conn.WriteError(err.Error())
 		return nil
 	}
-	if v == nil {
-		conn.WriteNull()
-	} else {
-		conn.WriteArray(len(*v))
-		for _, val := range *v {
-			conn.WriteBulk(val)
-		}
-	}
+	conn.WriteInt64(num)
 	return nil
 }
 
-func zrangebyscore(s *Server, conn Conn, cmd Command) error {
-	if len(cmd.Args) < 4 {
+func decr(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return nil
 	}
-	v, err := s.db.ZrangeByScore(string(cmd.Args[1]), cmd.Args[2], cmd.Args[3], cmd.Args[4:]...)
+	num, err := s.selectedDB(conn).IncrBy(string(cmd.Args[1]), -1)
 	if err != nil {
 		conn.WriteError(err.Error())
 		return nil
 	}
-	if v == nil {
-		conn.WriteNull()
-	} else {
-		conn.WriteArray(len(*v))
-		for _, val := range *v {
-			conn.WriteBulk(val)
+	conn.WriteInt64(num)
+	return nil
+}
+
+func incrby(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	incr, ok := string2ll(cmd.Args[2])
+	if !ok {
+		conn.WriteError(ErrNotInteger.Error())
+		return nil
+	}
+	num, err := s.selectedDB(conn).IncrBy(string(cmd.Args[1]), incr)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt64(num)
+	return nil
+}
+
+func decrby(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	decr, ok := string2ll(cmd.Args[2])
+	if !ok {
+		conn.WriteError(ErrNotInteger.Error())
+		return nil
+	}
+	if decr == math.MinInt64 {
+		conn.WriteError("ERR decrement would overflow")
+		return nil
+	}
+	num, err := s.selectedDB(conn).IncrBy(string(cmd.Args[1]), -decr)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt64(num)
+	return nil
+}
+
+func incrbyfloat(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	incr, ok := string2ld(cmd.Args[2])
+	if !ok {
+		conn.WriteError(ErrNotFloat.Error())
+		return nil
+	}
+	v, err := s.selectedDB(conn).IncrByFloat(string(cmd.Args[1]), incr)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteBulk(v)
+	return nil
+}
+
+func get(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Get(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if v == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulk(v)
+	}
+
+	return nil
+}
+
+func mget(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Mget(cmd.Args[1:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(len(v))
+	for _, val := range v {
+		if val == nil {
+			conn.WriteNull()
+		} else {
+			conn.WriteBulk(val)
+		}
+	}
+	return nil
+}
+
+func getset(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Getset(string(cmd.Args[1]), cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if v == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+
+func getdel(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Getdel(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if v == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+
+func getex(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	var when int64
+	persist := false
+	args := cmd.Args[2:]
+	if len(args) == 1 && strings.ToLower(string(args[0])) == "persist" {
+		persist = true
+	} else if len(args) > 0 {
+		//EX PX EXAT PXAT和SET的参数一样
+		if len(args) != 2 || strings.ToLower(string(args[0])) == "keepttl" {
+			conn.WriteError("ERR syntax error")
+			return nil
+		}
+		flags, t, err := parseSetArgs(args, mstime())
+		if err != nil || flags != 0 {
+			if err == nil {
+				err = errors.New("ERR syntax error")
+			}
+			conn.WriteError(strings.Replace(err.Error(), "'set'", "'getex'", 1))
+			return nil
+		}
+		when = t
+	}
+	v, err := s.selectedDB(conn).Getex(string(cmd.Args[1]), when, persist)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if v == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+
+func setnx(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Setnx(string(cmd.Args[1]), cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
+	return nil
+}
+
+func setexGeneric(s *Server, conn Conn, cmd Command, unit int64) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	n, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		conn.WriteError("ERR value is not an integer or out of range")
+		return nil
+	}
+	now := mstime()
+	if n <= 0 || n > (math.MaxInt64-now)/unit {
+		conn.WriteError("ERR invalid expire time in '" + strings.ToLower(string(cmd.Args[0])) + "' command")
+		return nil
+	}
+	err = s.selectedDB(conn).Setex(string(cmd.Args[1]), cmd.Args[3], now+n*unit)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteString("OK")
+	return nil
+}
+
+func setex(s *Server, conn Conn, cmd Command) error {
+	return setexGeneric(s, conn, cmd, 1000)
+}
+
+func psetex(s *Server, conn Conn, cmd Command) error {
+	return setexGeneric(s, conn, cmd, 1)
+}
+
+func msetnx(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 || len(cmd.Args)%2 != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Msetnx(cmd.Args[1:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
+	return nil
+}
+
+func strlen(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Strlen(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
+	return nil
+}
+
+func getrange(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	start, ok1 := string2ll(cmd.Args[2])
+	end, ok2 := string2ll(cmd.Args[3])
+	if !ok1 || !ok2 {
+		conn.WriteError(ErrNotInteger.Error())
+		return nil
+	}
+	v, err := s.selectedDB(conn).Getrange(string(cmd.Args[1]), start, end)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteBulk(v)
+	return nil
+}
+
+func setrange(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	offset, ok := string2ll(cmd.Args[2])
+	if !ok {
+		conn.WriteError(ErrNotInteger.Error())
+		return nil
+	}
+	num, err := s.selectedDB(conn).Setrange(string(cmd.Args[1]), offset, cmd.Args[3])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
+	return nil
+}
+
+func lcs(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	var getlen, getidx, withmatchlen bool
+	var minmatchlen int64
+	for i := 3; i < len(cmd.Args); i++ {
+		switch strings.ToLower(string(cmd.Args[i])) {
+		case "len":
+			getlen = true
+		case "idx":
+			getidx = true
+		case "withmatchlen":
+			withmatchlen = true
+		case "minmatchlen":
+			if i+1 >= len(cmd.Args) {
+				conn.WriteError("ERR syntax error")
+				return nil
+			}
+			i++
+			n, ok := string2ll(cmd.Args[i])
+			if !ok {
+				conn.WriteError(ErrNotInteger.Error())
+				return nil
+			}
+			if n > 0 {
+				minmatchlen = n
+			}
+		default:
+			conn.WriteError("ERR syntax error")
+			return nil
+		}
+	}
+	if getlen && getidx {
+		conn.WriteError("ERR If you want both the length and indexes, please just use IDX.")
+		return nil
+	}
+	v, matches, err := s.selectedDB(conn).Lcs(string(cmd.Args[1]), string(cmd.Args[2]), getidx, minmatchlen)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	switch {
+	case getlen:
+		conn.WriteInt(len(v))
+	case getidx:
+		conn.WriteArray(4)
+		conn.WriteBulkString("matches")
+		conn.WriteArray(len(matches))
+		for _, match := range matches {
+			if withmatchlen {
+				conn.WriteArray(3)
+			} else {
+				conn.WriteArray(2)
+			}
+			conn.WriteArray(2)
+			conn.WriteInt(match.A[0])
+			conn.WriteInt(match.A[1])
+			conn.WriteArray(2)
+			conn.WriteInt(match.B[0])
+			conn.WriteInt(match.B[1])
+			if withmatchlen {
+				conn.WriteInt(match.Len)
+			}
+		}
+		conn.WriteBulkString("len")
+		conn.WriteInt(len(v))
+	default:
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+
+func appendCmd(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Append(string(cmd.Args[1]), cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
+	return nil
+}
+
+//list opt
+func lpush(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Lpush(cmd.Args[1:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(v)
+	return nil
+}
+
+func rpush(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Rpush(cmd.Args[1:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(v)
+	return nil
+}
+
+func lpushx(s *Server, conn Conn, cmd Command) error {
+	return pushxGeneric(s, conn, cmd, true)
+}
+
+func rpushx(s *Server, conn Conn, cmd Command) error {
+	return pushxGeneric(s, conn, cmd, false)
+}
+
+func pushxGeneric(s *Server, conn Conn, cmd Command, left bool) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Pushx(left, cmd.Args[1:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(v)
+	return nil
+}
+
+func lpop(s *Server, conn Conn, cmd Command) error {
+	return popGeneric(s, conn, cmd, true)
+}
+
+func rpop(s *Server, conn Conn, cmd Command) error {
+	return popGeneric(s, conn, cmd, false)
+}
+
+//popGeneric implements LPOP and RPOP, with a count the reply is an array
+func popGeneric(s *Server, conn Conn, cmd Command, left bool) error {
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	count := 1
+	if len(cmd.Args) == 3 {
+		var err error
+		if count, err = strconv.Atoi(string(cmd.Args[2])); err != nil || count < 0 {
+			conn.WriteError("ERR value is out of range, must be positive")
+			return nil
+		}
+	}
+	vals, err := s.selectedDB(conn).Pop(string(cmd.Args[1]), left, count)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if len(cmd.Args) == 2 {
+		if len(vals) == 0 {
+			conn.WriteNull()
+		} else {
+			conn.WriteBulk(vals[0])
+		}
+		return nil
+	}
+	if vals == nil {
+		conn.WriteNull()
+		return nil
+	}
+	conn.WriteArray(len(vals))
+	for _, v := range vals {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+
+//parseListSide parses the LEFT|RIGHT argument of LMOVE and LMPOP
+func parseListSide(arg []byte) (left bool, err error) {
+	switch strings.ToLower(string(arg)) {
+	case "left":
+		return true, nil
+	case "right":
+		return false, nil
+	}
+	return false, ErrSyntax
+}
+
+func lmpop(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	numkeys, err := strconv.Atoi(string(cmd.Args[1]))
+	if err != nil || numkeys <= 0 {
+		conn.WriteError("ERR numkeys should be greater than 0")
+		return nil
+	}
+	//numkeys可能很大，和参数个数比较的时候不能对它做加法
+	if numkeys > len(cmd.Args)-3 {
+		conn.WriteError(ErrSyntax.Error())
+		return nil
+	}
+	keys := make([]string, numkeys)
+	for i := range keys {
+		keys[i] = string(cmd.Args[2+i])
+	}
+	left, err := parseListSide(cmd.Args[numkeys+2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	count := 1
+	opts := cmd.Args[numkeys+3:]
+	if len(opts) > 0 {
+		if len(opts) != 2 || strings.ToLower(string(opts[0])) != "count" {
+			conn.WriteError(ErrSyntax.Error())
+			return nil
+		}
+		if count, err = strconv.Atoi(string(opts[1])); err != nil || count <= 0 {
+			conn.WriteError("ERR count should be greater than 0")
+			return nil
+		}
+	}
+	key, vals, err := s.selectedDB(conn).Lmpop(keys, left, count)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if key == "" {
+		conn.WriteNull()
+		return nil
+	}
+	conn.WriteArray(2)
+	conn.WriteBulkString(key)
+	conn.WriteArray(len(vals))
+	for _, v := range vals {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+
+func lmove(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 5 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	srcLeft, err1 := parseListSide(cmd.Args[3])
+	dstLeft, err2 := parseListSide(cmd.Args[4])
+	if err1 != nil || err2 != nil {
+		conn.WriteError(ErrSyntax.Error())
+		return nil
+	}
+	return lmoveGeneric(s, conn, cmd, srcLeft, dstLeft)
+}
+
+func rpoplpush(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	return lmoveGeneric(s, conn, cmd, false, true)
+}
+
+func lmoveGeneric(s *Server, conn Conn, cmd Command, srcLeft, dstLeft bool) error {
+	v, err := s.selectedDB(conn).Lmove(string(cmd.Args[1]), string(cmd.Args[2]), srcLeft, dstLeft)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if v == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+
+func blpop(s *Server, conn Conn, cmd Command) error {
+	return bpopGeneric(s, conn, cmd, true)
+}
+
+func brpop(s *Server, conn Conn, cmd Command) error {
+	return bpopGeneric(s, conn, cmd, false)
+}
+
+func bpopGeneric(s *Server, conn Conn, cmd Command, left bool) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	timeout, err := parseTimeout(cmd.Args[len(cmd.Args)-1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	keys := make([]string, len(cmd.Args)-2)
+	for i := range keys {
+		keys[i] = string(cmd.Args[1+i])
+	}
+	r, ok := blockingPop(s, conn, keys, left, 1, timeout)
+	if !ok {
+		return nil
+	}
+	conn.WriteArray(2)
+	conn.WriteBulkString(r.key)
+	conn.WriteBulk(r.vals[0])
+	return nil
+}
+
+func blmpop(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 5 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	timeout, err := parseTimeout(cmd.Args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	numkeys, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil || numkeys <= 0 {
+		conn.WriteError("ERR numkeys should be greater than 0")
+		return nil
+	}
+	if numkeys > len(cmd.Args)-4 {
+		conn.WriteError(ErrSyntax.Error())
+		return nil
+	}
+	keys := make([]string, numkeys)
+	for i := range keys {
+		keys[i] = string(cmd.Args[3+i])
+	}
+	left, err := parseListSide(cmd.Args[numkeys+3])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	count := 1
+	opts := cmd.Args[numkeys+4:]
+	if len(opts) > 0 {
+		if len(opts) != 2 || strings.ToLower(string(opts[0])) != "count" {
+			conn.WriteError(ErrSyntax.Error())
+			return nil
+		}
+		if count, err = strconv.Atoi(string(opts[1])); err != nil || count <= 0 {
+			conn.WriteError("ERR count should be greater than 0")
+			return nil
+		}
+	}
+	r, ok := blockingPop(s, conn, keys, left, count, timeout)
+	if !ok {
+		return nil
+	}
+	conn.WriteArray(2)
+	conn.WriteBulkString(r.key)
+	conn.WriteArray(len(r.vals))
+	for _, v := range r.vals {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+
+//blockingPop pops from the first non empty list among keys, waiting up to
+//timeout for one. When ok is false the reply has already been written.
+func blockingPop(s *Server, conn Conn, keys []string, left bool, count int, timeout time.Duration) (blockedReply, bool) {
+	db := s.selectedDB(conn)
+	key, vals, w, err := db.Blmpop(keys, left, count)
+	r := blockedReply{key: key, vals: vals, err: err}
+	if w != nil {
+		var served bool
+		if r, served = s.waitBlocked(conn, db, w, timeout); !served {
+			conn.WriteNull()
+			return r, false
+		}
+	}
+	if r.err != nil {
+		conn.WriteError(r.err.Error())
+		return r, false
+	}
+	return r, true
+}
+
+func blmove(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 6 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	srcLeft, err1 := parseListSide(cmd.Args[3])
+	dstLeft, err2 := parseListSide(cmd.Args[4])
+	if err1 != nil || err2 != nil {
+		conn.WriteError(ErrSyntax.Error())
+		return nil
+	}
+	return blmoveGeneric(s, conn, cmd, srcLeft, dstLeft, cmd.Args[5])
+}
+
+func brpoplpush(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	return blmoveGeneric(s, conn, cmd, false, true, cmd.Args[3])
+}
+
+func blmoveGeneric(s *Server, conn Conn, cmd Command, srcLeft, dstLeft bool, timeoutArg []byte) error {
+	timeout, err := parseTimeout(timeoutArg)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	db := s.selectedDB(conn)
+	v, w, err := db.Blmove(string(cmd.Args[1]), string(cmd.Args[2]), srcLeft, dstLeft)
+	if w != nil {
+		r, served := s.waitBlocked(conn, db, w, timeout)
+		if !served {
+			conn.WriteNull()
+			return nil
+		}
+		err = r.err
+		if len(r.vals) > 0 {
+			v = r.vals[0]
+		}
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteBulk(v)
+	return nil
+}
+
+func lrange(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	start, err1 := strconv.Atoi(string(cmd.Args[2]))
+	end, err2 := strconv.Atoi(string(cmd.Args[3]))
+	if err1 != nil || err2 != nil {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Lrange(string(cmd.Args[1]), start, end)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if v == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteArray(len(*v))
+		for _, val := range *v {
+			conn.WriteBulk(val)
+		}
+	}
+	return nil
+}
+
+func llen(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	n, err := s.selectedDB(conn).Llen(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(n)
+	return nil
+}
+
+func lindex(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	index, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(ErrNotInteger.Error())
+		return nil
+	}
+	v, err := s.selectedDB(conn).Lindex(string(cmd.Args[1]), index)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if v == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+
+func lset(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	index, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(ErrNotInteger.Error())
+		return nil
+	}
+	if err := s.selectedDB(conn).Lset(string(cmd.Args[1]), index, cmd.Args[3]); err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteString("OK")
+	return nil
+}
+
+func linsert(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 5 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	var after bool
+	switch strings.ToLower(string(cmd.Args[2])) {
+	case "after":
+		after = true
+	case "before":
+	default:
+		conn.WriteError(ErrSyntax.Error())
+		return nil
+	}
+	n, err := s.selectedDB(conn).Linsert(string(cmd.Args[1]), after, cmd.Args[3], cmd.Args[4])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(n)
+	return nil
+}
+
+func lrem(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	count, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(ErrNotInteger.Error())
+		return nil
+	}
+	n, err := s.selectedDB(conn).Lrem(string(cmd.Args[1]), count, cmd.Args[3])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(n)
+	return nil
+}
+
+func ltrim(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	start, err1 := strconv.Atoi(string(cmd.Args[2]))
+	stop, err2 := strconv.Atoi(string(cmd.Args[3]))
+	if err1 != nil || err2 != nil {
+		conn.WriteError(ErrNotInteger.Error())
+		return nil
+	}
+	if err := s.selectedDB(conn).Ltrim(string(cmd.Args[1]), start, stop); err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteString("OK")
+	return nil
+}
+
+func lpos(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	rank, count, maxlen := 1, 0, 0
+	withCount := false
+	for i := 3; i < len(cmd.Args); i += 2 {
+		if i+1 >= len(cmd.Args) {
+			conn.WriteError(ErrSyntax.Error())
+			return nil
+		}
+		n, err := strconv.Atoi(string(cmd.Args[i+1]))
+		if err != nil {
+			conn.WriteError(ErrNotInteger.Error())
+			return nil
+		}
+		switch strings.ToLower(string(cmd.Args[i])) {
+		case "rank":
+			if n == 0 || n == math.MinInt64 {
+				conn.WriteError("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+				return nil
+			}
+			rank = n
+		case "count":
+			if n < 0 {
+				conn.WriteError("ERR COUNT can't be negative")
+				return nil
+			}
+			count = n
+			withCount = true
+		case "maxlen":
+			if n < 0 {
+				conn.WriteError("ERR MAXLEN can't be negative")
+				return nil
+			}
+			maxlen = n
+		default:
+			conn.WriteError(ErrSyntax.Error())
+			return nil
+		}
+	}
+	if !withCount {
+		count = 1
+	}
+	pos, err := s.selectedDB(conn).Lpos(string(cmd.Args[1]), cmd.Args[2], rank, count, maxlen)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if withCount {
+		conn.WriteArray(len(pos))
+		for _, p := range pos {
+			conn.WriteInt(p)
+		}
+	} else if len(pos) == 0 {
+		conn.WriteNull()
+	} else {
+		conn.WriteInt(pos[0])
+	}
+	return nil
+}
+
+//set opt
+func sadd(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Sadd(string(cmd.Args[1]), cmd.Args[2:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+
+func spop(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	count := 1
+	if len(cmd.Args) == 3 {
+		var err error
+		if count, err = strconv.Atoi(string(cmd.Args[2])); err != nil || count < 0 {
+			conn.WriteError("ERR value is out of range, must be positive")
+			return nil
+		}
+	}
+	v, err := s.selectedDB(conn).Spop(string(cmd.Args[1]), count)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	writeRandomMembers(conn, v, len(cmd.Args) == 3)
+	return nil
+}
+
+func srandmember(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	count := 1
+	if len(cmd.Args) == 3 {
+		var err error
+		if count, err = strconv.Atoi(string(cmd.Args[2])); err != nil {
+			conn.WriteError(ErrNotInteger.Error())
+			return nil
+		}
+	}
+	v, err := s.selectedDB(conn).Srandmember(string(cmd.Args[1]), count)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	writeRandomMembers(conn, v, len(cmd.Args) == 3)
+	return nil
+}
+
+//writeRandomMembers writes the reply of SPOP and SRANDMEMBER, an array when
+//a count was given and a single member otherwise
+func writeRandomMembers(conn Conn, members [][]byte, withCount bool) {
+	if withCount {
+		conn.WriteArray(len(members))
+		for _, member := range members {
+			conn.WriteBulk(member)
+		}
+	} else if len(members) == 0 {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulk(members[0])
+	}
+}
+
+func srem(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	n, err := s.selectedDB(conn).Srem(string(cmd.Args[1]), cmd.Args[2:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(n)
+	return nil
+}
+
+func sismember(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	ret, err := s.selectedDB(conn).Smismember(string(cmd.Args[1]), cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(ret[0])
+	return nil
+}
+
+func smismember(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	ret, err := s.selectedDB(conn).Smismember(string(cmd.Args[1]), cmd.Args[2:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(len(ret))
+	for _, v := range ret {
+		conn.WriteInt(v)
+	}
+	return nil
+}
+
+func scard(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	n, err := s.selectedDB(conn).Scard(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(n)
+	return nil
+}
+
+func smove(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	n, err := s.selectedDB(conn).Smove(string(cmd.Args[1]), string(cmd.Args[2]), cmd.Args[3])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(n)
+	return nil
+}
+
+func sinter(s *Server, conn Conn, cmd Command) error {
+	return setAlgebraGeneric(s, conn, cmd, "inter", false)
+}
+
+func sunion(s *Server, conn Conn, cmd Command) error {
+	return setAlgebraGeneric(s, conn, cmd, "union", false)
+}
+
+func sdiff(s *Server, conn Conn, cmd Command) error {
+	return setAlgebraGeneric(s, conn, cmd, "diff", false)
+}
+
+func sinterstore(s *Server, conn Conn, cmd Command) error {
+	return setAlgebraGeneric(s, conn, cmd, "inter", true)
+}
+
+func sunionstore(s *Server, conn Conn, cmd Command) error {
+	return setAlgebraGeneric(s, conn, cmd, "union", true)
+}
+
+func sdiffstore(s *Server, conn Conn, cmd Command) error {
+	return setAlgebraGeneric(s, conn, cmd, "diff", true)
+}
+
+//setAlgebraGeneric implements SINTER, SUNION, SDIFF and their STORE variants,
+//which take the destination as first argument
+func setAlgebraGeneric(s *Server, conn Conn, cmd Command, op string, store bool) error {
+	minArgs := 2
+	if store {
+		minArgs = 3
+	}
+	if len(cmd.Args) < minArgs {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	keys := make([]string, len(cmd.Args)-minArgs+1)
+	for i := range keys {
+		keys[i] = string(cmd.Args[minArgs-1+i])
+	}
+	db := s.selectedDB(conn)
+	if store {
+		n, err := db.SetStore(op, string(cmd.Args[1]), keys...)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return nil
+		}
+		conn.WriteInt(n)
+		return nil
+	}
+	var members [][]byte
+	var err error
+	switch op {
+	case "inter":
+		members, err = db.Sinter(keys...)
+	case "union":
+		members, err = db.Sunion(keys...)
+	default:
+		members, err = db.Sdiff(keys...)
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(len(members))
+	for _, member := range members {
+		conn.WriteBulk(member)
+	}
+	return nil
+}
+
+func sintercard(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	numkeys, err := strconv.Atoi(string(cmd.Args[1]))
+	if err != nil || numkeys <= 0 {
+		conn.WriteError("ERR numkeys should be greater than 0")
+		return nil
+	}
+	if numkeys > len(cmd.Args)-2 {
+		conn.WriteError("ERR Number of keys can't be greater than number of args")
+		return nil
+	}
+	keys := make([]string, numkeys)
+	for i := range keys {
+		keys[i] = string(cmd.Args[2+i])
+	}
+	limit := 0
+	opts := cmd.Args[2+numkeys:]
+	if len(opts) > 0 {
+		if len(opts) != 2 || strings.ToLower(string(opts[0])) != "limit" {
+			conn.WriteError(ErrSyntax.Error())
+			return nil
+		}
+		if limit, err = strconv.Atoi(string(opts[1])); err != nil {
+			conn.WriteError(ErrNotInteger.Error())
+			return nil
+		}
+		if limit < 0 {
+			conn.WriteError("ERR LIMIT can't be negative")
+			return nil
+		}
+	}
+	n, err := s.selectedDB(conn).Sintercard(keys, limit)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(n)
+	return nil
+}
+
+func smembers(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Smembers(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if v == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteArray(len(v))
+		for _, val := range v {
+			conn.WriteBulk(val)
+		}
+	}
+	return nil
+}
+
+//hash opt
+func hset(s *Server, conn Conn, cmd Command) error {
+	return hsetGeneric(s, conn, cmd, false)
+}
+func hmset(s *Server, conn Conn, cmd Command) error {
+	return hsetGeneric(s, conn, cmd, true)
+}
+func hsetGeneric(s *Server, conn Conn, cmd Command, hmset bool) error {
+	if len(cmd.Args) < 4 || len(cmd.Args)%2 != 0 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Hset(string(cmd.Args[1]), cmd.Args[2:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else if hmset {
+		conn.WriteString("OK")
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+func hsetnx(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Hsetnx(string(cmd.Args[1]), string(cmd.Args[2]), cmd.Args[3])
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+func hget(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Hget(string(cmd.Args[1]), string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else if v == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+func hmget(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Hmget(string(cmd.Args[1]), cmd.Args[2:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(len(v))
+	for _, val := range v {
+		if val == nil {
+			conn.WriteNull()
+		} else {
+			conn.WriteBulk(val)
+		}
+	}
+	return nil
+}
+func hdel(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Hdel(string(cmd.Args[1]), cmd.Args[2:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+func hexists(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Hexists(string(cmd.Args[1]), string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+func hlen(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Hlen(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+func hstrlen(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Hstrlen(string(cmd.Args[1]), string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+func hkeys(s *Server, conn Conn, cmd Command) error {
+	return hkeysGeneric(s, conn, cmd, false)
+}
+func hvals(s *Server, conn Conn, cmd Command) error {
+	return hkeysGeneric(s, conn, cmd, true)
+}
+func hkeysGeneric(s *Server, conn Conn, cmd Command, vals bool) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	var v [][]byte
+	var err error
+	if vals {
+		v, err = s.selectedDB(conn).Hvals(string(cmd.Args[1]))
+	} else {
+		v, err = s.selectedDB(conn).Hkeys(string(cmd.Args[1]))
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(len(v))
+	for _, val := range v {
+		conn.WriteBulk(val)
+	}
+	return nil
+}
+func hincrby(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	incr, ok := string2ll(cmd.Args[3])
+	if !ok {
+		conn.WriteError(ErrNotInteger.Error())
+		return nil
+	}
+	num, err := s.selectedDB(conn).Hincrby(string(cmd.Args[1]), string(cmd.Args[2]), incr)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt64(num)
+	}
+	return nil
+}
+func hincrbyfloat(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	incr, ok := string2ld(cmd.Args[3])
+	if !ok {
+		conn.WriteError(ErrNotFloat.Error())
+		return nil
+	}
+	v, err := s.selectedDB(conn).Hincrbyfloat(string(cmd.Args[1]), string(cmd.Args[2]), incr)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+//hrandfield key [count [withvalues]]
+func hrandfield(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	if len(cmd.Args) > 4 || (len(cmd.Args) == 4 && strings.ToLower(string(cmd.Args[3])) != "withvalues") {
+		conn.WriteError(ErrSyntax.Error())
+		return nil
+	}
+	count := 1
+	if len(cmd.Args) > 2 {
+		var err error
+		if count, err = strconv.Atoi(string(cmd.Args[2])); err != nil {
+			conn.WriteError(ErrNotInteger.Error())
+			return nil
+		}
+	}
+	withValues := len(cmd.Args) == 4
+	v, err := s.selectedDB(conn).Hrandfield(string(cmd.Args[1]), count, withValues)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if len(cmd.Args) == 2 {
+		if len(v) == 0 {
+			conn.WriteNull()
+		} else {
+			conn.WriteBulk(v[0])
+		}
+		return nil
+	}
+	conn.WriteArray(len(v))
+	for _, val := range v {
+		conn.WriteBulk(val)
+	}
+	return nil
+}
+//parseFieldsArg parses the FIELDS numfields field... part of the hash field
+//expiration commands
+func parseFieldsArg(args [][]byte) ([][]byte, error) {
+	if len(args) < 2 || strings.ToLower(string(args[0])) != "fields" {
+		return nil, errors.New("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, err := strconv.Atoi(string(args[1]))
+	if err != nil || n <= 0 {
+		return nil, errors.New("ERR Parameter `numFields` should be greater than 0")
+	}
+	if n != len(args)-2 {
+		return nil, errors.New("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+//hexpire key time [NX|XX|GT|LT] FIELDS numfields field...
+func hexpireGeneric(s *Server, conn Conn, cmd Command, base int64, unit int64) error {
+	if len(cmd.Args) < 6 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	when, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		conn.WriteError(ErrNotInteger.Error())
+		return nil
+	}
+	if when < 0 {
+		conn.WriteError("ERR invalid expire time, must be >= 0")
+		return nil
+	}
+	if when > (hashFieldMaxExpire-base)/unit {
+		conn.WriteError("ERR invalid expire time in '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	cond, i := 0, 3
+	switch strings.ToLower(string(cmd.Args[3])) {
+	case "nx":
+		cond = hexpireNX
+	case "xx":
+		cond = hexpireXX
+	case "gt":
+		cond = hexpireGT
+	case "lt":
+		cond = hexpireLT
+	}
+	if cond != 0 {
+		i++
+	}
+	fields, err := parseFieldsArg(cmd.Args[i:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	ret, err := s.selectedDB(conn).HpexpireAt(string(cmd.Args[1]), base+when*unit, cond, fields)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(len(ret))
+	for _, v := range ret {
+		conn.WriteInt(v)
+	}
+	return nil
+}
+
+func hexpire(s *Server, conn Conn, cmd Command) error {
+	return hexpireGeneric(s, conn, cmd, mstime(), 1000)
+}
+
+func hpexpire(s *Server, conn Conn, cmd Command) error {
+	return hexpireGeneric(s, conn, cmd, mstime(), 1)
+}
+
+func hexpireat(s *Server, conn Conn, cmd Command) error {
+	return hexpireGeneric(s, conn, cmd, 0, 1000)
+}
+
+func hpexpireat(s *Server, conn Conn, cmd Command) error {
+	return hexpireGeneric(s, conn, cmd, 0, 1)
+}
+
+//httlGeneric replies the time to live of fields in unit, or with abs their
+//deadline as a unix time
+func httlGeneric(s *Server, conn Conn, cmd Command, unit int64, abs bool) error {
+	if len(cmd.Args) < 5 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	fields, err := parseFieldsArg(cmd.Args[2:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	ret, err := s.selectedDB(conn).HexpireTimes(string(cmd.Args[1]), fields)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	now := mstime()
+	conn.WriteArray(len(ret))
+	for _, when := range ret {
+		if when < 0 {
+			conn.WriteInt64(when)
+		} else if abs {
+			conn.WriteInt64(when / unit)
+		} else if ttl := when - now; ttl > 0 {
+			conn.WriteInt64((ttl + unit/2) / unit)
+		} else {
+			conn.WriteInt64(0)
+		}
+	}
+	return nil
+}
+
+func httl(s *Server, conn Conn, cmd Command) error {
+	return httlGeneric(s, conn, cmd, 1000, false)
+}
+
+func hpttl(s *Server, conn Conn, cmd Command) error {
+	return httlGeneric(s, conn, cmd, 1, false)
+}
+
+func hexpiretime(s *Server, conn Conn, cmd Command) error {
+	return httlGeneric(s, conn, cmd, 1000, true)
+}
+
+func hpexpiretime(s *Server, conn Conn, cmd Command) error {
+	return httlGeneric(s, conn, cmd, 1, true)
+}
+
+func hpersist(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 5 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	fields, err := parseFieldsArg(cmd.Args[2:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	ret, err := s.selectedDB(conn).Hpersist(string(cmd.Args[1]), fields)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(len(ret))
+	for _, v := range ret {
+		conn.WriteInt(v)
+	}
+	return nil
+}
+
+func hgetall(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Hgetall(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteArray(len(v) * 2)
+		for key, val := range v {
+			conn.WriteBulkString(key)
+			conn.WriteBulk(val)
+		}
+	}
+	return nil
+}
+
+//sort set
+
+var zaddFlags = map[string]int{
+	"nx":   zaddNX,
+	"xx":   zaddXX,
+	"gt":   zaddGT,
+	"lt":   zaddLT,
+	"ch":   zaddCH,
+	"incr": zaddINCR,
+}
+
+//zadd key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func zadd(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	flags, i := 0, 2
+	for ; i < len(cmd.Args); i++ {
+		flag := zaddFlags[strings.ToLower(string(cmd.Args[i]))]
+		if flag == 0 {
+			break
+		}
+		flags |= flag
+	}
+	args := cmd.Args[i:]
+	if len(args) == 0 || len(args)%2 != 0 {
+		conn.WriteError(ErrSyntax.Error())
+		return nil
+	}
+	if flags&zaddNX != 0 && flags&zaddXX != 0 {
+		conn.WriteError("ERR XX and NX options at the same time are not compatible")
+		return nil
+	}
+	if (flags&zaddGT != 0 && flags&(zaddNX|zaddLT) != 0) || (flags&zaddLT != 0 && flags&zaddNX != 0) {
+		conn.WriteError("ERR GT, LT, and/or NX options at the same time are not compatible")
+		return nil
+	}
+	if flags&zaddINCR != 0 && len(args) != 2 {
+		conn.WriteError("ERR INCR option supports a single increment-element pair")
+		return nil
+	}
+	scores := make([]float64, len(args)/2)
+	members := make([]string, len(args)/2)
+	for j := range scores {
+		var ok bool
+		if scores[j], ok = string2d(args[j*2]); !ok {
+			conn.WriteError(ErrNotFloat.Error())
+			return nil
+		}
+		members[j] = string(args[j*2+1])
+	}
+	num, score, err := s.selectedDB(conn).Zadd(string(cmd.Args[1]), flags, scores, members)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else if flags&zaddINCR == 0 {
+		conn.WriteInt(num)
+	} else if score == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulkString(d2string(*score))
+	}
+	return nil
+}
+func zincrby(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	incr, ok := string2d(cmd.Args[2])
+	if !ok {
+		conn.WriteError(ErrNotFloat.Error())
+		return nil
+	}
+	_, score, err := s.selectedDB(conn).Zadd(string(cmd.Args[1]), zaddINCR, []float64{incr}, []string{string(cmd.Args[3])})
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteBulkString(d2string(*score))
+	}
+	return nil
+}
+
+func zrem(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Zrem(string(cmd.Args[1]), cmd.Args[2:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+
+func zscore(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Zmscore(string(cmd.Args[1]), cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else if v[0] == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulkString(d2string(*v[0]))
+	}
+	return nil
+}
+
+func zmscore(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Zmscore(string(cmd.Args[1]), cmd.Args[2:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(len(v))
+	for _, score := range v {
+		if score == nil {
+			conn.WriteNull()
+		} else {
+			conn.WriteBulkString(d2string(*score))
+		}
+	}
+	return nil
+}
+
+func zcard(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Zcard(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+
+func zcount(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	r, err := parseZrangeSpec(cmd.Args[2], cmd.Args[3])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	num, err := s.selectedDB(conn).Zcount(string(cmd.Args[1]), r)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+
+//zrank key member [WITHSCORE]
+func zrankGeneric(s *Server, conn Conn, cmd Command, rev bool) error {
+	if len(cmd.Args) != 3 && len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	withScore := len(cmd.Args) == 4
+	if withScore && strings.ToLower(string(cmd.Args[3])) != "withscore" {
+		conn.WriteError(ErrSyntax.Error())
+		return nil
+	}
+	rank, score, err := s.selectedDB(conn).Zrank(string(cmd.Args[1]), string(cmd.Args[2]), rev)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else if rank < 0 {
+		conn.WriteNull()
+	} else if withScore {
+		conn.WriteArray(2)
+		conn.WriteInt(rank)
+		conn.WriteBulkString(d2string(score))
+	} else {
+		conn.WriteInt(rank)
+	}
+	return nil
+}
+
+func zrank(s *Server, conn Conn, cmd Command) error {
+	return zrankGeneric(s, conn, cmd, false)
+}
+
+func zrevrank(s *Server, conn Conn, cmd Command) error {
+	return zrankGeneric(s, conn, cmd, true)
+}
+
+//zrangeAuto marks the unified ZRANGE syntax, where BYSCORE, BYLEX and REV are options
+const zrangeAuto = -1
+
+//zrangeGeneric implements ZRANGE, ZRANGESTORE and the older range commands:
+//[ZRANGESTORE dst] key start stop [BYSCORE|BYLEX] [REV] [WITHSCORES] [LIMIT offset count]
+func zrangeGeneric(s *Server, conn Conn, cmd Command, store bool, by int, rev bool) error {
+	first := 1
+	if store {
+		first = 2
+	}
+	if len(cmd.Args) < first+3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	unified := by == zrangeAuto
+	if unified {
+		by = zrangeByRank
+	}
+	q := &zrangeQuery{limit: -1}
+	withScores, hasLimit := false, false
+	for i := first + 3; i < len(cmd.Args); i++ {
+		opt := strings.ToLower(string(cmd.Args[i]))
+		switch {
+		case opt == "withscores" && !store:
+			withScores = true
+		case opt == "limit" && i+2 < len(cmd.Args):
+			var err1, err2 error
+			q.offset, err1 = strconv.Atoi(string(cmd.Args[i+1]))
+			q.limit, err2 = strconv.Atoi(string(cmd.Args[i+2]))
+			if err1 != nil || err2 != nil {
+				conn.WriteError(ErrNotInteger.Error())
+				return nil
+			}
+			hasLimit = true
+			i += 2
+		case opt == "byscore" && unified && by == zrangeByRank:
+			by = zrangeByScore
+		case opt == "bylex" && unified && by == zrangeByRank:
+			by = zrangeByLex
+		case opt == "rev" && unified:
+			rev = true
+		default:
+			conn.WriteError(ErrSyntax.Error())
+			return nil
+		}
+	}
+	if hasLimit && by == zrangeByRank {
+		conn.WriteError("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+		return nil
+	}
+	if withScores && by == zrangeByLex {
+		conn.WriteError("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+		return nil
+	}
+	q.by, q.rev = by, rev
+	min, max := cmd.Args[first+1], cmd.Args[first+2]
+	if rev && by != zrangeByRank {
+		//反向的时候先给出max再给出min
+		min, max = max, min
+	}
+	var err error
+	switch by {
+	case zrangeByRank:
+		var err1, err2 error
+		q.start, err1 = strconv.Atoi(string(min))
+		q.stop, err2 = strconv.Atoi(string(max))
+		if err1 != nil || err2 != nil {
+			err = ErrNotInteger
+		}
+	case zrangeByScore:
+		q.score, err = parseZrangeSpec(min, max)
+	case zrangeByLex:
+		q.lex, err = parseZlexSpec(min, max)
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if store {
+		num, err := s.selectedDB(conn).Zrangestore(string(cmd.Args[1]), string(cmd.Args[2]), q)
+		if err != nil {
+			conn.WriteError(err.Error())
+		} else {
+			conn.WriteInt(num)
+		}
+		return nil
+	}
+	v, err := s.selectedDB(conn).Zrange(string(cmd.Args[1]), q, withScores)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(len(v))
+	for _, val := range v {
+		conn.WriteBulk(val)
+	}
+	return nil
+}
+
+func zrange(s *Server, conn Conn, cmd Command) error {
+	return zrangeGeneric(s, conn, cmd, false, zrangeAuto, false)
+}
+
+func zrangestore(s *Server, conn Conn, cmd Command) error {
+	return zrangeGeneric(s, conn, cmd, true, zrangeAuto, false)
+}
+
+func zrevrange(s *Server, conn Conn, cmd Command) error {
+	return zrangeGeneric(s, conn, cmd, false, zrangeByRank, true)
+}
+
+func zrangebyscore(s *Server, conn Conn, cmd Command) error {
+	return zrangeGeneric(s, conn, cmd, false, zrangeByScore, false)
+}
+
+func zrevrangebyscore(s *Server, conn Conn, cmd Command) error {
+	return zrangeGeneric(s, conn, cmd, false, zrangeByScore, true)
+}
+
+func zrangebylex(s *Server, conn Conn, cmd Command) error {
+	return zrangeGeneric(s, conn, cmd, false, zrangeByLex, false)
+}
+
+func zrevrangebylex(s *Server, conn Conn, cmd Command) error {
+	return zrangeGeneric(s, conn, cmd, false, zrangeByLex, true)
+}
+
+func zlexcount(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	r, err := parseZlexSpec(cmd.Args[2], cmd.Args[3])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	num, err := s.selectedDB(conn).Zlexcount(string(cmd.Args[1]), r)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+
+func zremrangebylex(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	r, err := parseZlexSpec(cmd.Args[2], cmd.Args[3])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	num, err := s.selectedDB(conn).Zremrangebylex(string(cmd.Args[1]), r)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else {
+		conn.WriteInt(num)
+	}
+	return nil
+}
+
+//key expire
+func expireGeneric(s *Server, conn Conn, cmd Command, base int64, unit int64) error {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	when, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		conn.WriteError("ERR value is not an integer or out of range")
+		return nil
+	}
+	if when > (math.MaxInt64-base)/unit || when < (math.MinInt64+base)/unit {
+		conn.WriteError("ERR invalid expire time in '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).PexpireAt(string(cmd.Args[1]), base+when*unit)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
+	return nil
+}
+
+func expire(s *Server, conn Conn, cmd Command) error {
+	return expireGeneric(s, conn, cmd, mstime(), 1000)
+}
+
+func pexpire(s *Server, conn Conn, cmd Command) error {
+	return expireGeneric(s, conn, cmd, mstime(), 1)
+}
+
+func expireat(s *Server, conn Conn, cmd Command) error {
+	return expireGeneric(s, conn, cmd, 0, 1000)
+}
+
+func pexpireat(s *Server, conn Conn, cmd Command) error {
+	return expireGeneric(s, conn, cmd, 0, 1)
+}
+
+func ttlGeneric(s *Server, conn Conn, cmd Command, unit int64) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	ttl, err := s.selectedDB(conn).Pttl(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if ttl > 0 {
+		//like redis, round to the nearest second
+		ttl = (ttl + unit/2) / unit
+	}
+	conn.WriteInt64(ttl)
+	return nil
+}
+
+func ttl(s *Server, conn Conn, cmd Command) error {
+	return ttlGeneric(s, conn, cmd, 1000)
+}
+
+func pttl(s *Server, conn Conn, cmd Command) error {
+	return ttlGeneric(s, conn, cmd, 1)
+}
+
+func persist(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Persist(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
+	return nil
+}
+
+//keyspace
+func exists(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Exists(cmd.Args[1:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
+	return nil
+}
+
+func typ(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	t, err := s.selectedDB(conn).Type(string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteString(t)
+	return nil
+}
+
+//object implements OBJECT ENCODING, the only subcommand supported
+func object(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	sub := strings.ToLower(string(cmd.Args[1]))
+	if sub != "encoding" {
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'. Try OBJECT HELP.")
+		return nil
+	}
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for 'object|" + sub + "' command")
+		return nil
+	}
+	enc, err := s.selectedDB(conn).ObjectEncoding(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if enc == "" {
+		conn.WriteNull()
+		return nil
+	}
+	conn.WriteBulkString(enc)
+	return nil
+}
+
+func dbsize(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	num, err := s.selectedDB(conn).Dbsize()
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteInt(num)
+	return nil
+}
+
+func randomkey(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).RandomKey()
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	if v == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulk(v)
+	}
+	return nil
+}
+
+func keys(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	v, err := s.selectedDB(conn).Keys(cmd.Args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(len(v))
+	for _, val := range v {
+		conn.WriteBulk(val)
+	}
+	return nil
+}
+
+func scan(s *Server, conn Conn, cmd Command) error {
+	if len(cmd.Args) < 2 || len(cmd.Args)%2 != 0 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return nil
+	}
+	cursor, err := strconv.ParseUint(string(cmd.Args[1]), 10, 64)
+	if err != nil {
+		conn.WriteError("ERR invalid cursor")
+		return nil
+	}
+	count := 10
+	var pattern []byte
+	var t string
+	for i := 2; i < len(cmd.Args); i += 2 {
+		switch strings.ToLower(string(cmd.Args[i])) {
+		case "match":
+			pattern = cmd.Args[i+1]
+			if string(pattern) == "*" {
+				pattern = nil
+			}
+		case "count":
+			count, err = strconv.Atoi(string(cmd.Args[i+1]))
+			if err != nil {
+				conn.WriteError("ERR value is not an integer or out of range")
+				return nil
+			}
+			if count < 1 {
+				conn.WriteError("ERR syntax error")
+				return nil
+			}
+		case "type":
+			t = strings.ToLower(string(cmd.Args[i+1]))
+		default:
+			conn.WriteError("ERR syntax error")
+			return nil
 		}
 	}
+	next, v, err := s.selectedDB(conn).Scan(cursor, count, pattern, t)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil
+	}
+	conn.WriteArray(2)
+	conn.WriteBulkString(strconv.FormatUint(next, 10))
+	conn.WriteArray(len(v))
+	for _, val := range v {
+		conn.WriteBulk(val)
+	}
 	return nil
 }
 
@@ -351,20 +2786,135 @@ func init() {
 	registerCmd("set", set)
 	registerCmd("get", get)
 	registerCmd("del", del)
+	registerCmd("getrange", getrange)
+	registerCmd("setrange", setrange)
+	registerCmd("lcs", lcs)
+	registerCmd("setbit", setbit)
+	registerCmd("getbit", getbit)
+	registerCmd("bitcount", bitcount)
+	registerCmd("bitpos", bitpos)
+	registerCmd("bitop", bitop)
+	registerCmd("bitfield", bitfield)
+	registerCmd("bitfield_ro", bitfieldRO)
+	registerCmd("pfadd", pfadd)
+	registerCmd("pfcount", pfcount)
+	registerCmd("pfmerge", pfmerge)
 	registerCmd("incr", incr)
+	registerCmd("decr", decr)
+	registerCmd("incrby", incrby)
+	registerCmd("decrby", decrby)
+	registerCmd("incrbyfloat", incrbyfloat)
 	registerCmd("lpush", lpush)
 	registerCmd("rpush", rpush)
 	registerCmd("lpop", lpop)
 	registerCmd("rpop", rpop)
+	registerCmd("lpushx", lpushx)
+	registerCmd("rpushx", rpushx)
+	registerCmd("lmpop", lmpop)
+	registerCmd("lmove", lmove)
+	registerCmd("rpoplpush", rpoplpush)
+	registerCmd("blpop", blpop)
+	registerCmd("brpop", brpop)
+	registerCmd("blmpop", blmpop)
+	registerCmd("blmove", blmove)
+	registerCmd("brpoplpush", brpoplpush)
 	registerCmd("lrange", lrange)
+	registerCmd("llen", llen)
+	registerCmd("lindex", lindex)
+	registerCmd("lset", lset)
+	registerCmd("linsert", linsert)
+	registerCmd("lrem", lrem)
+	registerCmd("ltrim", ltrim)
+	registerCmd("lpos", lpos)
 	registerCmd("sadd", sadd)
 	registerCmd("spop", spop)
 	registerCmd("smembers", smembers)
+	registerCmd("srandmember", srandmember)
+	registerCmd("srem", srem)
+	registerCmd("sismember", sismember)
+	registerCmd("smismember", smismember)
+	registerCmd("scard", scard)
+	registerCmd("smove", smove)
+	registerCmd("sinter", sinter)
+	registerCmd("sunion", sunion)
+	registerCmd("sdiff", sdiff)
+	registerCmd("sinterstore", sinterstore)
+	registerCmd("sunionstore", sunionstore)
+	registerCmd("sdiffstore", sdiffstore)
+	registerCmd("sintercard", sintercard)
 	registerCmd("mset", mset)
 	registerCmd("zrange", zrange)
 	registerCmd("zrangebyscore", zrangebyscore)
+	registerCmd("zrangestore", zrangestore)
+	registerCmd("zrevrange", zrevrange)
+	registerCmd("zrevrangebyscore", zrevrangebyscore)
+	registerCmd("zrangebylex", zrangebylex)
+	registerCmd("zrevrangebylex", zrevrangebylex)
+	registerCmd("zlexcount", zlexcount)
+	registerCmd("zremrangebylex", zremrangebylex)
 	registerCmd("zadd", zadd)
+	registerCmd("zincrby", zincrby)
+	registerCmd("zrem", zrem)
+	registerCmd("zscore", zscore)
+	registerCmd("zmscore", zmscore)
+	registerCmd("zcard", zcard)
+	registerCmd("zcount", zcount)
+	registerCmd("zrank", zrank)
+	registerCmd("zrevrank", zrevrank)
 	registerCmd("hset", hset)
 	registerCmd("hget", hget)
+	registerCmd("hmset", hmset)
+	registerCmd("hsetnx", hsetnx)
+	registerCmd("hincrby", hincrby)
+	registerCmd("hincrbyfloat", hincrbyfloat)
+	registerCmd("hrandfield", hrandfield)
+	registerCmd("hexpire", hexpire)
+	registerCmd("hpexpire", hpexpire)
+	registerCmd("hexpireat", hexpireat)
+	registerCmd("hpexpireat", hpexpireat)
+	registerCmd("httl", httl)
+	registerCmd("hpttl", hpttl)
+	registerCmd("hexpiretime", hexpiretime)
+	registerCmd("hpexpiretime", hpexpiretime)
+	registerCmd("hpersist", hpersist)
+	registerCmd("hmget", hmget)
+	registerCmd("hdel", hdel)
+	registerCmd("hexists", hexists)
+	registerCmd("hlen", hlen)
+	registerCmd("hstrlen", hstrlen)
+	registerCmd("hkeys", hkeys)
+	registerCmd("hvals", hvals)
 	registerCmd("hgetall", hgetall)
+	registerCmd("expire", expire)
+	registerCmd("pexpire", pexpire)
+	registerCmd("expireat", expireat)
+	registerCmd("pexpireat", pexpireat)
+	registerCmd("ttl", ttl)
+	registerCmd("pttl", pttl)
+	registerCmd("persist", persist)
+	registerCmd("exists", exists)
+	registerCmd("type", typ)
+	registerCmd("object", object)
+	registerCmd("dbsize", dbsize)
+	registerCmd("randomkey", randomkey)
+	registerCmd("keys", keys)
+	registerCmd("scan", scan)
+	registerCmd("move", move)
+	registerCmd("swapdb", swapdb)
+	registerCmd("flushdb", flushdb)
+	registerCmd("flushall", flushall)
+	registerCmd("unlink", unlink)
+	registerCmd("rename", rename)
+	registerCmd("renamenx", renamenx)
+	registerCmd("copy", copyCmd)
+	registerCmd("mget", mget)
+	registerCmd("getset", getset)
+	registerCmd("getdel", getdel)
+	registerCmd("getex", getex)
+	registerCmd("setnx", setnx)
+	registerCmd("setex", setex)
+	registerCmd("psetex", psetex)
+	registerCmd("msetnx", msetnx)
+	registerCmd("strlen", strlen)
+	registerCmd("append", appendCmd)
 }
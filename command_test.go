@@ -0,0 +1,438 @@
+package newredis
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseSetArgs(t *testing.T) {
+	const now = 1000000
+	tests := []struct {
+		args  string
+		flags int
+		when  int64
+		err   string
+	}{
+		{"", 0, 0, ""},
+		{"nx", setNX, 0, ""},
+		{"XX get", setXX | setGet, 0, ""},
+		{"ex 10", 0, now + 10000, ""},
+		{"px 10", 0, now + 10, ""},
+		{"exat 10", 0, 10000, ""},
+		{"PXAT 10", 0, 10, ""},
+		{"keepttl nx", setKeepTTL | setNX, 0, ""},
+		{"get ex 1 xx", setGet | setXX, now + 1000, ""},
+		{"nx xx", 0, 0, "ERR syntax error"},
+		{"xx nx", 0, 0, "ERR syntax error"},
+		{"ex 10 px 10", 0, 0, "ERR syntax error"},
+		{"ex 10 keepttl", 0, 0, "ERR syntax error"},
+		{"keepttl px 10", 0, 0, "ERR syntax error"},
+		{"ex", 0, 0, "ERR syntax error"},
+		{"foo", 0, 0, "ERR syntax error"},
+		{"ex abc", 0, 0, "ERR value is not an integer or out of range"},
+		{"ex 0", 0, 0, "ERR invalid expire time in 'set' command"},
+		{"px -1", 0, 0, "ERR invalid expire time in 'set' command"},
+		{"ex 9223372036854775", 0, 0, "ERR invalid expire time in 'set' command"},
+		{"pxat 9223372036854775807", 0, 9223372036854775807, ""},
+	}
+	for _, tt := range tests {
+		var args [][]byte
+		for _, arg := range strings.Fields(tt.args) {
+			args = append(args, []byte(arg))
+		}
+		flags, when, err := parseSetArgs(args, now)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: error %v, want %s", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || flags != tt.flags || when != tt.when {
+			t.Errorf("%q: got %d %d %v, want %d %d", tt.args, flags, when, err, tt.flags, tt.when)
+		}
+	}
+}
+
+func TestSetCommand(t *testing.T) {
+	runCases(t, newTestServer(), []cmdCase{
+		{"set k v xx", "(nil)"},
+		{"set k v nx", "+OK"},
+		{"set k w nx", "(nil)"},
+		{"set k w xx get", "$v"},
+		{"get k", "$w"},
+		{"set k x get", "$w"},
+		{"set nokey v get", "(nil)"},
+		{"set k v ex 100", "+OK"},
+		{"ttl k", ":100"},
+		{"set k v keepttl", "+OK"},
+		{"ttl k", ":100"},
+		{"set k v", "+OK"},
+		{"ttl k", ":-1"},
+		{"set k v exat 1", "+OK"},
+		{"exists k", ":0"},
+		{"rpush l a", ":1"},
+		{"set l v get", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{"set l v", "+OK"},
+		{"set k", "ERR wrong number of arguments for 'set' command"},
+	})
+}
+
+//TestSetReplay checks that relative deadlines are logged as absolute ones, a
+//replay later on must not move them
+func TestSetReplay(t *testing.T) {
+	s := newTestServer()
+	runCases(t, s, []cmdCase{
+		{"set a v ex 100", "+OK"},
+		{"set b v px 100000", "+OK"},
+		{"set c v exat 99999999999", "+OK"},
+		{"set d v pxat 99999999999999", "+OK"},
+		{"set a w keepttl", "+OK"},
+		{"set b w", "+OK"},
+		{"set c w nx", "(nil)"},
+		{"set e v xx", "(nil)"},
+		{"set d w xx get px 5000", "$v"},
+	})
+	r := replay(t)
+	assertSameKeyspace(t, s, r)
+	for _, key := range []string{"a", "c", "d"} {
+		if r.dbs[0].Expires[key] != s.dbs[0].Expires[key] {
+			t.Errorf("deadline of %s: replayed %d, want %d", key, r.dbs[0].Expires[key], s.dbs[0].Expires[key])
+		}
+	}
+}
+
+func TestStringCommands(t *testing.T) {
+	runCases(t, newTestServer(), []cmdCase{
+		{"mset a 1 b 2", "+OK"},
+		{"mget a nokey b", "*3 $1 (nil) $2"},
+		{"getset a 3", "$1"},
+		{"getset nokey v", "(nil)"},
+		{"getdel nokey", "$v"},
+		{"getdel nokey", "(nil)"},
+		{"getdel a", "$3"},
+		{"exists a", ":0"},
+		{"setnx a 1", ":1"},
+		{"setnx a 2", ":0"},
+		{"get a", "$1"},
+		{"setex a 100 v", "+OK"},
+		{"ttl a", ":100"},
+		{"psetex a 5000 v", "+OK"},
+		{"ttl a", ":5"},
+		{"setex a 0 v", "ERR invalid expire time in 'setex' command"},
+		{"psetex a -1 v", "ERR invalid expire time in 'psetex' command"},
+		{"setex a x v", "ERR value is not an integer or out of range"},
+		{"getex a persist", "$v"},
+		{"ttl a", ":-1"},
+		{"getex a ex 100", "$v"},
+		{"ttl a", ":100"},
+		{"getex a", "$v"},
+		{"ttl a", ":100"},
+		{"getex a keepttl", "ERR syntax error"},
+		{"getex a ex 0", "ERR invalid expire time in 'getex' command"},
+		{"getex a ex 1 px 1", "ERR syntax error"},
+		{"getex nokey ex 100", "(nil)"},
+		{"msetnx a 1 c 3", ":0"},
+		{"msetnx c 3 d 4", ":1"},
+		{"msetnx c", "ERR wrong number of arguments for 'msetnx' command"},
+		{"strlen c", ":1"},
+		{"strlen nokey", ":0"},
+		{"append c 45", ":3"},
+		{"append e xy", ":2"},
+		{"get c", "$345"},
+		{"rpush l a", ":1"},
+		{"strlen l", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{"getset l v", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{"mget l c", "*2 (nil) $345"},
+		{`set empty ""`, "+OK"},
+		{"get empty", "$"},
+		{"mget empty nokey", "*2 $ (nil)"},
+		{"getex empty", "$"},
+		{"getex empty ex 100", "$"},
+		{"ttl empty", ":100"},
+		{"strlen empty", ":0"},
+		{"exists empty", ":1"},
+	})
+}
+
+func TestStringCommandsReplay(t *testing.T) {
+	s := newTestServer()
+	runCases(t, s, []cmdCase{
+		{"set a v", "+OK"},
+		{"getex a ex 100", "$v"},
+		{"setex b 100 v", "+OK"},
+		{"psetex c 100000 v", "+OK"},
+		{"set d v ex 100", "+OK"},
+		{"getex d persist", "$v"},
+		{"getset b w", "$v"},
+		{"getdel c", "$v"},
+		{"setnx c v", ":1"},
+		{"msetnx e 1 f 2", ":1"},
+		{"append e 2", ":2"},
+	})
+	assertSameKeyspace(t, s, replay(t))
+}
+
+func TestIncrCommands(t *testing.T) {
+	runCases(t, newTestServer(), []cmdCase{
+		{"incr n", ":1"},
+		{"incrby n 9", ":10"},
+		{"decr n", ":9"},
+		{"decrby n 10", ":-1"},
+		{"set n 9223372036854775806", "+OK"},
+		{"incr n", ":9223372036854775807"},
+		{"incr n", "ERR increment or decrement would overflow"},
+		{"get n", "$9223372036854775807"},
+		{"set n -9223372036854775807", "+OK"},
+		{"decr n", ":-9223372036854775808"},
+		{"decrby n 1", "ERR increment or decrement would overflow"},
+		{"decrby n -9223372036854775808", "ERR decrement would overflow"},
+		{"incrby n 9223372036854775808", "ERR value is not an integer or out of range"},
+		{"set n 1.5", "+OK"},
+		{"incr n", "ERR value is not an integer or out of range"},
+		{"set n 01", "+OK"},
+		{"incr n", "ERR value is not an integer or out of range"},
+		{"set f 10.5", "+OK"},
+		{"incrbyfloat f 0.1", "$10.6"},
+		{"incrbyfloat f -5", "$5.6"},
+		{"set f 5.0e3", "+OK"},
+		{"incrbyfloat f 2.0e2", "$5200"},
+		{"incrbyfloat nokey 3", "$3"},
+		{"incrbyfloat f abc", "ERR value is not a valid float"},
+		{"incrbyfloat f inf", "ERR increment would produce NaN or Infinity"},
+		{"rpush l a", ":1"},
+		{"incr l", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+}
+
+func TestIncrCommandsReplay(t *testing.T) {
+	s := newTestServer()
+	runCases(t, s, []cmdCase{
+		{"set n 5 ex 100", "+OK"},
+		{"incrby n 10", ":15"},
+		{"decr n", ":14"},
+		{"incrbyfloat f 1.1", "$1.1"},
+		{"incrbyfloat f 2.2", "$3.3"},
+		{"set m 9223372036854775807", "+OK"},
+		{"incr m", "ERR increment or decrement would overflow"},
+	})
+	r := replay(t)
+	assertSameKeyspace(t, s, r)
+	runCases(t, r, []cmdCase{
+		{"get n", "$14"},
+		{"get f", "$3.3"},
+		{"ttl n", ":100"},
+	})
+}
+
+func TestListMoveCommands(t *testing.T) {
+	s := newTestServer()
+	runCases(t, s, []cmdCase{
+		{"lpushx l a", ":0"},
+		{"rpushx l a", ":0"},
+		{"rpush l a b c d e", ":5"},
+		{"lpushx l z", ":6"},
+		{"rpushx l f g", ":8"},
+		{"lpop l 2", "*2 $z $a"},
+		{"rpop l 2", "*2 $g $f"},
+		{"lpop l 0", "*0"},
+		{"lpop nokey 2", "(nil)"},
+		{"lpop l -1", "ERR value is out of range, must be positive"},
+		{"rpoplpush l l2", "$e"},
+		{"lmove l l2 left right", "$b"},
+		{"lmove l l left right", "$c"},
+		{"lrange l 0 -1", "*2 $d $c"},
+		{"lrange l2 0 -1", "*2 $e $b"},
+		{"lmove nokey l2 left left", "(nil)"},
+		{"lmove l l2 up left", "ERR syntax error"},
+		{"lmpop 2 nokey l2 right count 5", "*2 $l2 *2 $b $e"},
+		{"exists l2", ":0"},
+		{"lmpop 1 nokey left", "(nil)"},
+		{"lmpop 1 l left count 0", "ERR count should be greater than 0"},
+		{"lmpop 1 l left count", "ERR syntax error"},
+		{"lmpop 0 l left", "ERR numkeys should be greater than 0"},
+		{"lmpop -1 l left", "ERR numkeys should be greater than 0"},
+		{"lmpop 2 l left", "ERR syntax error"},
+		{"lmpop 9223372036854775807 l left", "ERR syntax error"},
+		{"lmpop 9223372036854775806 l left", "ERR syntax error"},
+		{"lmpop 99999999999999999999 l left", "ERR numkeys should be greater than 0"},
+		{"lmpop 1 l left", "*2 $l *1 $d"},
+		{"set s v", "+OK"},
+		{"lmpop 2 s l left", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{"lpushx s a", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+	assertSameKeyspace(t, s, replay(t))
+}
+
+//TestSetMaxIntsetEntries runs two servers with different limits side by side
+func TestSetMaxIntsetEntries(t *testing.T) {
+	small := newConfigServer(DefaultConfig().OpenWal("none").SetMaxIntsetEntries(2))
+	large := newConfigServer(DefaultConfig().OpenWal("none"))
+	cs, cl := &testConn{}, &testConn{}
+	for _, cmd := range []string{"sadd s 1 2", "sadd s 3", "sadd d 1"} {
+		do(small, cs, cmd)
+		do(large, cl, cmd)
+	}
+	tests := []struct {
+		s    *Server
+		cmd  string
+		want string
+	}{
+		{small, "object encoding s", "$hashtable"},
+		{large, "object encoding s", "$intset"},
+		{small, "object encoding d", "$intset"},
+		{small, "sadd d 2", ":1"},
+		{small, "object encoding d", "$intset"},
+		{small, "sadd d 3", ":1"},
+		{small, "object encoding d", "$hashtable"},
+		{large, "sinterstore i s s", ":3"},
+		{large, "object encoding i", "$intset"},
+		{small, "sinterstore i s s", ":3"},
+		{small, "object encoding i", "$hashtable"},
+		{small, "copy d d2", ":1"},
+		{small, "object encoding d2", "$hashtable"},
+	}
+	for _, tt := range tests {
+		if got := do(tt.s, &testConn{}, tt.cmd); got != tt.want {
+			t.Errorf("%s (limit %d): got %q, want %q", tt.cmd, tt.s.conf.setMaxIntsetEntries, got, tt.want)
+		}
+	}
+}
+
+func TestSetEncodingPersistence(t *testing.T) {
+	s := newTestServer()
+	c := &testConn{}
+	for i := 0; i < 600; i++ {
+		do(s, c, "sadd big "+strconv.Itoa(i))
+	}
+	runCases(t, s, []cmdCase{
+		{"sadd ints 5 -3 1", ":3"},
+		{"sadd words 1 a", ":2"},
+		{"sadd back 1 a", ":2"},
+		{"srem back a", ":1"},
+		{"scard big", ":600"},
+		{"object encoding big", "$hashtable"},
+		{"object encoding ints", "$intset"},
+		{"object encoding back", "$hashtable"},
+		{"smembers ints", "*3 $-3 $1 $5"},
+		{"copy ints ints2", ":1"},
+		{"object encoding ints2", "$intset"},
+	})
+	r := replay(t)
+	assertSameKeyspace(t, s, r)
+	b, err := s.getSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := newTestServer()
+	if err := loaded.recoverFromSnapshot(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, srv := range []*Server{r, loaded} {
+		runCases(t, srv, []cmdCase{
+			{"object encoding big", "$hashtable"},
+			{"object encoding ints", "$intset"},
+			{"object encoding words", "$hashtable"},
+			{"sadd ints x", ":1"},
+			{"object encoding ints", "$hashtable"},
+		})
+	}
+}
+
+//TestZaddFlags runs ZADD with each combination of flags on a missing member
+//and on a member of score 5
+func TestZaddFlags(t *testing.T) {
+	tests := []struct {
+		flags  string
+		exists bool
+		score  string
+		want   string
+		after  string //score of the member afterwards
+	}{
+		{"", false, "3", ":1", "$3"},
+		{"nx", false, "3", ":1", "$3"},
+		{"xx", false, "3", ":0", "(nil)"},
+		{"gt", false, "3", ":1", "$3"},
+		{"lt", false, "3", ":1", "$3"},
+		{"ch", false, "3", ":1", "$3"},
+		{"xx ch", false, "3", ":0", "(nil)"},
+		{"incr", false, "3", "$3", "$3"},
+		{"incr xx", false, "3", "(nil)", "(nil)"},
+		{"incr gt", false, "3", "$3", "$3"},
+
+		{"", true, "7", ":0", "$7"},
+		{"", true, "5", ":0", "$5"},
+		{"ch", true, "7", ":1", "$7"},
+		{"ch", true, "5", ":0", "$5"},
+		{"nx", true, "7", ":0", "$5"},
+		{"nx ch", true, "7", ":0", "$5"},
+		{"xx", true, "7", ":0", "$7"},
+		{"xx ch", true, "7", ":1", "$7"},
+		{"gt", true, "7", ":0", "$7"},
+		{"gt ch", true, "7", ":1", "$7"},
+		{"gt ch", true, "3", ":0", "$5"},
+		{"gt ch", true, "5", ":0", "$5"},
+		{"lt", true, "3", ":0", "$3"},
+		{"lt ch", true, "3", ":1", "$3"},
+		{"lt ch", true, "7", ":0", "$5"},
+		{"xx gt ch", true, "7", ":1", "$7"},
+		{"xx lt ch", true, "7", ":0", "$5"},
+		{"incr", true, "7", "$12", "$12"},
+		{"incr", true, "-5", "$0", "$0"},
+		{"incr nx", true, "7", "(nil)", "$5"},
+		{"incr xx", true, "7", "$12", "$12"},
+		{"incr gt", true, "7", "$12", "$12"},
+		{"incr gt", true, "-1", "(nil)", "$5"},
+		{"incr lt", true, "-1", "$4", "$4"},
+		{"incr lt", true, "1", "(nil)", "$5"},
+		{"incr ch", true, "0", "$5", "$5"},
+		{"CH GT", true, "+inf", ":1", "$inf"},
+
+		{"nx xx", true, "7", "ERR XX and NX options at the same time are not compatible", "$5"},
+		{"nx gt", true, "7", "ERR GT, LT, and/or NX options at the same time are not compatible", "$5"},
+		{"nx lt", false, "7", "ERR GT, LT, and/or NX options at the same time are not compatible", "(nil)"},
+		{"gt lt", true, "7", "ERR GT, LT, and/or NX options at the same time are not compatible", "$5"},
+		{"", true, "nan", "ERR value is not a valid float", "$5"},
+		{"", true, "abc", "ERR value is not a valid float", "$5"},
+		{"xx", true, "", "ERR syntax error", "$5"},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		c := &testConn{}
+		do(s, c, "zadd z 1 other")
+		if tt.exists {
+			do(s, c, "zadd z 5 m")
+		}
+		cmd := "zadd z " + tt.flags + " " + tt.score + " m"
+		if got := do(s, c, cmd); got != tt.want {
+			t.Errorf("%s (exists %v): got %q, want %q", cmd, tt.exists, got, tt.want)
+		}
+		if got := do(s, c, "zscore z m"); got != tt.after {
+			t.Errorf("%s (exists %v): score %q, want %q", cmd, tt.exists, got, tt.after)
+		}
+		assertSameKeyspace(t, s, replay(t))
+	}
+}
+
+func TestZaddMultiple(t *testing.T) {
+	s := newTestServer()
+	runCases(t, s, []cmdCase{
+		{"zadd z 1 a 2 b 3 c", ":3"},
+		{"zadd z 1 a 5 b 4 d", ":1"},
+		{"zadd z ch 1 a 6 b 5 e", ":2"},
+		{"zadd z xx ch 0 a 0 f", ":1"},
+		{"zadd z nx 9 a 9 g", ":1"},
+		{"zadd z 1 x 2 x", ":1"},
+		{"zscore z x", "$2"},
+		{"zadd z incr 1 a 2 b", "ERR INCR option supports a single increment-element pair"},
+		{"zadd z 1 a 2", "ERR syntax error"},
+		{"zadd z 1 a inf b -inf c", ":0"},
+		{"zincrby z inf b", "$inf"},
+		{"zincrby z -inf b", "ERR resulting score is not a number (NaN)"},
+		{"zadd z incr -inf b", "ERR resulting score is not a number (NaN)"},
+		{"zrange z 0 -1 withscores", "*14 $c $-inf $a $1 $x $2 $d $4 $e $5 $g $9 $b $inf"},
+		{"set s v", "+OK"},
+		{"zadd s 1 a", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+	assertSameKeyspace(t, s, replay(t))
+}
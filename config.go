@@ -7,6 +7,9 @@ type Config struct {
 	snapCount uint64
 	walsavetype string
 	sync      bool
+	databases int
+	protoMaxBulkLen int64
+	setMaxIntsetEntries int
 }
 
 func DefaultConfig() *Config {
@@ -17,6 +20,9 @@ func DefaultConfig() *Config {
 		datadir:"data/",
 		walsavetype:"aw",
 		sync : true,
+		databases: 16,
+		protoMaxBulkLen: 512 * 1024 * 1024,
+		setMaxIntsetEntries: 512,
 	}
 }
 
@@ -41,6 +47,30 @@ func (c *Config) Sync(b bool) *Config {
 	return c
 }
 
+func (c *Config) Databases(n int) *Config {
+	if n > 0 {
+		c.databases = n
+	}
+	return c
+}
+
+//ProtoMaxBulkLen sets the maximum size of a string value
+func (c *Config) ProtoMaxBulkLen(n int64) *Config {
+	if n > 0 {
+		c.protoMaxBulkLen = n
+	}
+	return c
+}
+
+//SetMaxIntsetEntries sets the size above which a set of integers stops
+//using the intset encoding
+func (c *Config) SetMaxIntsetEntries(n int) *Config {
+	if n >= 0 {
+		c.setMaxIntsetEntries = n
+	}
+	return c
+}
+
 func (c *Config) DataDir(w string) *Config {
 	c.datadir = w
 	return c
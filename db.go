@@ -0,0 +1,254 @@
+package newredis
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack"
+	"github.com/widaT/newredis/structure"
+)
+
+var (
+	ErrDBIndexOutOfRange = errors.New("ERR DB index is out of range")
+	ErrSameObject        = errors.New("ERR source and destination objects are the same")
+)
+
+const serverSnapshotVersion = 2
+
+//serverSnapshot holds every logical database by index, empty databases are left out
+type serverSnapshot struct {
+	Version int
+	Dbs     map[int]*memdbSnapshot
+}
+
+//selectedDB returns the database selected by conn
+func (s *Server) selectedDB(conn Conn) *Memdb {
+	return s.dbs[conn.SelectedDB()]
+}
+
+//database returns the database at index or ErrDBIndexOutOfRange
+func (s *Server) database(index int) (*Memdb, error) {
+	if index < 0 || index >= len(s.dbs) {
+		return nil, ErrDBIndexOutOfRange
+	}
+	return s.dbs[index], nil
+}
+
+//lockDBs write locks the given databases in index order, so that two commands
+//working on the same pair of databases can't deadlock
+func lockDBs(a, b *Memdb) func() {
+	if a.index > b.index {
+		a, b = b, a
+	}
+	a.rwmu.Lock()
+	if a != b {
+		b.rwmu.Lock()
+	}
+	return func() {
+		if a != b {
+			b.rwmu.Unlock()
+		}
+		a.rwmu.Unlock()
+	}
+}
+
+func (s *Server) rlockAll() {
+	for _, db := range s.dbs {
+		db.rwmu.RLock()
+	}
+}
+
+func (s *Server) runlockAll() {
+	for i := len(s.dbs) - 1; i >= 0; i-- {
+		s.dbs[i].rwmu.RUnlock()
+	}
+}
+
+func (s *Server) setRecovering(b bool) {
+	for _, db := range s.dbs {
+		db.recovebool = b
+	}
+}
+
+//getSnapshot serializes all the databases, the caller must hold their locks
+func (s *Server) getSnapshot() ([]byte, error) {
+	snap := serverSnapshot{
+		Version: serverSnapshotVersion,
+		Dbs:     make(map[int]*memdbSnapshot),
+	}
+	for _, db := range s.dbs {
+		if len(db.keys) > 0 {
+			snap.Dbs[db.index] = db.snapshot()
+		}
+	}
+	return msgpack.Marshal(&snap)
+}
+
+//recoverFromSnapshot loads a snapshot, snapshots written before multiple
+//databases existed are loaded into db 0
+func (s *Server) recoverFromSnapshot(b []byte) error {
+	var snap serverSnapshot
+	if err := msgpack.Unmarshal(b, &snap); err != nil {
+		return err
+	}
+	if snap.Version < serverSnapshotVersion {
+		dbsnap, err := decodeMemdbSnapshot(b, s.conf.setMaxIntsetEntries)
+		if err != nil {
+			return err
+		}
+		snap.Dbs = map[int]*memdbSnapshot{0: dbsnap}
+	}
+	for index, dbsnap := range snap.Dbs {
+		db, err := s.database(index)
+		if err != nil {
+			return err
+		}
+		db.restore(dbsnap)
+	}
+	return nil
+}
+
+//reset empties the database, with async the old objects are released by a
+//background goroutine instead of the caller
+func (m *Memdb) reset(async bool) {
+	old := m.keys
+	m.keys = make(map[string]*Object)
+	m.Expires = make(map[string]int64)
+	m.keyIndex = structure.NewSkipList()
+	m.hashTTLKeys = make(map[string]struct{})
+	if async {
+		go freeObjects(old)
+	}
+}
+
+func freeObjects(objs map[string]*Object) {
+	for key, o := range objs {
+		o.free()
+		delete(objs, key)
+	}
+}
+
+func (m *Memdb) FlushDB(async bool) error {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "flushdb"})
+		if err != nil {
+			return err
+		}
+	}
+	m.reset(async)
+	return nil
+}
+
+func (s *Server) FlushAll(async bool) error {
+	for _, db := range s.dbs {
+		db.rwmu.Lock()
+		defer db.rwmu.Unlock()
+	}
+	if !s.dbs[0].recovebool {
+		err := s.w.save(&Opt{Method: "flushall"})
+		if err != nil {
+			return err
+		}
+	}
+	for _, db := range s.dbs {
+		db.reset(async)
+	}
+	return nil
+}
+
+//Move moves key from the database src to dst, it returns 0 when the key does
+//not exist in src or already exists in dst
+func (s *Server) Move(src *Memdb, key string, dstIndex int) (int, error) {
+	dst, err := s.database(dstIndex)
+	if err != nil {
+		return 0, err
+	}
+	if src == dst {
+		return 0, ErrSameObject
+	}
+	defer lockDBs(src, dst)()
+	src.expireIfNeeded(key)
+	dst.expireIfNeeded(key)
+	o, found := src.keys[key]
+	if !found || dst.exists(key) {
+		return 0, nil
+	}
+	if !src.recovebool {
+		err := src.save(&Opt{Method: "move", Key: key, Args: [][]byte{[]byte(strconv.Itoa(dstIndex))}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	when, hasExpire := src.Expires[key]
+	src.delKey(key)
+	dst.setKey(key, o)
+	if hasExpire {
+		dst.Expires[key] = when
+	}
+	dst.serveBlocked(key)
+	return 1, nil
+}
+
+//SwapDB exchanges the content of two databases, clients keep their selected index
+func (s *Server) SwapDB(a, b int) error {
+	dba, err := s.database(a)
+	if err != nil {
+		return err
+	}
+	dbb, err := s.database(b)
+	if err != nil {
+		return err
+	}
+	if dba == dbb {
+		return nil
+	}
+	defer lockDBs(dba, dbb)()
+	if !dba.recovebool {
+		err := s.w.save(&Opt{Method: "swapdb", Args: [][]byte{[]byte(strconv.Itoa(a)), []byte(strconv.Itoa(b))}})
+		if err != nil {
+			return err
+		}
+	}
+	dba.keys, dbb.keys = dbb.keys, dba.keys
+	dba.Expires, dbb.Expires = dbb.Expires, dba.Expires
+	dba.keyIndex, dbb.keyIndex = dbb.keyIndex, dba.keyIndex
+	dba.hashTTLKeys, dbb.hashTTLKeys = dbb.hashTTLKeys, dba.hashTTLKeys
+	//阻塞的客户端留在原来的编号上，换过来的数据可能满足它们
+	dba.serveAllBlocked()
+	dbb.serveAllBlocked()
+	return nil
+}
+
+//Copy copies the value of key in src to dstkey in the database dstIndex,
+//with replace an existing dstkey is overwritten
+func (s *Server) Copy(src *Memdb, key, dstkey string, dstIndex int, replace bool) (int, error) {
+	dst, err := s.database(dstIndex)
+	if err != nil {
+		return 0, err
+	}
+	if src == dst && key == dstkey {
+		return 0, ErrSameObject
+	}
+	defer lockDBs(src, dst)()
+	src.expireIfNeeded(key)
+	dst.expireIfNeeded(dstkey)
+	o, found := src.keys[key]
+	if !found || (!replace && dst.exists(dstkey)) {
+		return 0, nil
+	}
+	if !src.recovebool {
+		err := src.save(&Opt{Method: "copy", Key: key, Args: [][]byte{[]byte(dstkey), []byte(strconv.Itoa(dstIndex))}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	dst.delKey(dstkey)
+	dst.setKey(dstkey, o.dup(dstkey))
+	if when, found := src.Expires[key]; found {
+		dst.Expires[dstkey] = when
+	}
+	dst.serveBlocked(dstkey)
+	return 1, nil
+}
@@ -0,0 +1,151 @@
+package newredis
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	activeExpireCycleLookups = 20                    //每轮抽样的key数量
+	activeExpireCycleTimeout = 25 * time.Millisecond //单次主动过期最多占用的时间
+)
+
+//mstime returns the unix time in milliseconds
+func mstime() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+//exists reports whether key holds a value, expired or not
+func (m *Memdb) exists(key string) bool {
+	_, found := m.keys[key]
+	return found
+}
+
+//expired reports whether key has a deadline in the past.
+//wal回放期间不判断过期，过期删除已经作为del记录在wal里
+func (m *Memdb) expired(key string) bool {
+	if m.recovebool {
+		return false
+	}
+	when, found := m.Expires[key]
+	return found && when <= mstime()
+}
+
+//expireIfNeeded removes key when it is expired, the caller must hold the write lock.
+//If the del can not be written to the wal the key stays in memory, it is still
+//invisible to readers and the next lookup tries again.
+func (m *Memdb) expireIfNeeded(key string) bool {
+	if !m.expired(key) {
+		return false
+	}
+	if err := m.save(&Opt{Method: "del", Args: [][]byte{[]byte(key)}}); err != nil {
+		log.Printf("raft-redis: failed to expire key %q (%v)", key, err)
+		return false
+	}
+	m.delKey(key)
+	return true
+}
+
+//delKey removes key from the keyspace together with its deadline
+func (m *Memdb) delKey(key string) {
+	delete(m.keys, key)
+	delete(m.Expires, key)
+	delete(m.hashTTLKeys, key)
+	m.removeKey(key)
+}
+
+//PexpireAt sets the absolute deadline of key in unix milliseconds
+func (m *Memdb) PexpireAt(key string, when int64) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	m.expireIfNeeded(key)
+	if !m.exists(key) {
+		return 0, nil
+	}
+	//deadline already passed, delete the key right now
+	if when <= mstime() && !m.recovebool {
+		err := m.save(&Opt{Method: "del", Args: [][]byte{[]byte(key)}})
+		if err != nil {
+			return 0, err
+		}
+		m.delKey(key)
+		return 1, nil
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "pexpireat", Key: key, Args: [][]byte{[]byte(strconv.FormatInt(when, 10))}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	m.Expires[key] = when
+	return 1, nil
+}
+
+func (m *Memdb) Persist(key string) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	m.expireIfNeeded(key)
+	if _, found := m.Expires[key]; !found {
+		return 0, nil
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "persist", Key: key})
+		if err != nil {
+			return 0, err
+		}
+	}
+	delete(m.Expires, key)
+	return 1, nil
+}
+
+//Pttl returns the remaining time to live in milliseconds,
+//-2 if the key does not exist and -1 if the key has no deadline
+func (m *Memdb) Pttl(key string) (int64, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	if m.expired(key) || !m.exists(key) {
+		return -2, nil
+	}
+	when, found := m.Expires[key]
+	if !found {
+		return -1, nil
+	}
+	ttl := when - mstime()
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, nil
+}
+
+//activeExpireCycle samples keys with a deadline and removes the expired ones,
+//like redis it repeats while more than 25% of the sampled keys were expired.
+//Then the expired fields of some hashes are removed.
+func (m *Memdb) activeExpireCycle() {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	start := time.Now()
+	for {
+		sampled, expired := 0, 0
+		for key := range m.Expires {
+			if sampled >= activeExpireCycleLookups {
+				break
+			}
+			sampled++
+			if m.expireIfNeeded(key) {
+				expired++
+			}
+		}
+		if expired <= activeExpireCycleLookups/4 || time.Since(start) > activeExpireCycleTimeout {
+			break
+		}
+	}
+	m.activeExpireHashFields()
+}
+
+func (m *Memdb) activeExpire(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		m.activeExpireCycle()
+	}
+}
@@ -0,0 +1,82 @@
+package newredis
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestExpireCommands(t *testing.T) {
+	runCases(t, newTestServer(), []cmdCase{
+		{"set k v", "+OK"},
+		{"ttl k", ":-1"},
+		{"expire k 100", ":1"},
+		{"ttl k", ":100"},
+		{"pexpire k 5000", ":1"},
+		{"ttl k", ":5"},
+		{"persist k", ":1"},
+		{"persist k", ":0"},
+		{"pttl k", ":-1"},
+		{"ttl nokey", ":-2"},
+		{"expire nokey 10", ":0"},
+		{"expire k abc", "ERR value is not an integer or out of range"},
+		{"expireat k 1", ":1"},
+		{"exists k", ":0"},
+		{"set k v", "+OK"},
+		{"pexpireat k 99999999999999", ":1"},
+		{"ttl k", ":" + strconv.FormatInt((99999999999999-mstime()+500)/1000, 10)},
+		{"expire k -1", ":1"},
+		{"get k", "(nil)"},
+	})
+}
+
+func TestLazyExpireLogsDel(t *testing.T) {
+	s := newTestServer()
+	runCases(t, s, []cmdCase{
+		{"set k v", "+OK"},
+		{"expire k 100", ":1"},
+	})
+	s.dbs[0].Expires["k"] = mstime() - 1
+	//reads only hide the key, a write removes it
+	runCases(t, s, []cmdCase{
+		{"get k", "(nil)"},
+		{"exists k", ":0"},
+		{"rpush k a", ":1"},
+		{"ttl k", ":-1"},
+	})
+	var opt Opt
+	if err := msgpack.Unmarshal(ents[len(ents)-2].Data, &opt); err != nil {
+		t.Fatal(err)
+	}
+	if opt.Method != "del" || len(opt.Args) != 1 || string(opt.Args[0]) != "k" {
+		t.Fatalf("wal entry %+v, want del k", opt)
+	}
+	assertSameKeyspace(t, s, replay(t))
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	s := newTestServer()
+	c := &testConn{}
+	db := s.dbs[0]
+	for i := 0; i < 100; i++ {
+		key := "k" + strconv.Itoa(i)
+		do(s, c, "set "+key+" v ex 100")
+		if i%2 == 0 {
+			db.Expires[key] = mstime() - 1
+		}
+	}
+	//keep running until sampling has seen every key
+	for i := 0; i < 100 && len(db.keys) > 50; i++ {
+		db.activeExpireCycle()
+	}
+	if len(db.keys) != 50 {
+		t.Fatalf("%d keys left, want 50", len(db.keys))
+	}
+	for key := range db.keys {
+		if db.expired(key) {
+			t.Errorf("expired key %s left", key)
+		}
+	}
+	assertSameKeyspace(t, s, replay(t))
+}
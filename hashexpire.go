@@ -0,0 +1,258 @@
+package newredis
+
+import (
+	"log"
+	"strconv"
+)
+
+//conditions of HEXPIRE and its variants
+const (
+	hexpireNX = 1 << iota //only fields without a deadline
+	hexpireXX             //only fields with a deadline
+	hexpireGT             //only if the new deadline is later
+	hexpireLT             //only if the new deadline is earlier
+)
+
+//hashFieldMaxExpire is the largest deadline of a hash field in unix milliseconds
+const hashFieldMaxExpire = 1<<48 - 1
+
+//per field replies of HEXPIRE and HPERSIST
+const (
+	hfeNoField  = -2
+	hfeNoTTL    = -1
+	hfeCondFail = 0
+	hfeOk       = 1
+	hfeDeleted  = 2
+)
+
+//fieldExpired reports whether field of the hash o has a deadline in the past.
+//wal回放期间不判断过期，和key的过期一样
+func (m *Memdb) fieldExpired(o *Object, field string) bool {
+	if m.recovebool || o.FieldExpires == nil {
+		return false
+	}
+	when, found := o.FieldExpires[field]
+	return found && when <= mstime()
+}
+
+//hashLen returns the number of fields of o that are not expired
+func (m *Memdb) hashLen(o *Object) int {
+	n := len(o.Hash)
+	for field := range o.FieldExpires {
+		if m.fieldExpired(o, field) {
+			n--
+		}
+	}
+	return n
+}
+
+//setFieldTTL sets the deadline of field, keeping track of the hashes that
+//have fields with a deadline for the active expire cycle
+func (m *Memdb) setFieldTTL(key string, o *Object, field string, when int64) {
+	if o.FieldExpires == nil {
+		o.FieldExpires = make(map[string]int64)
+	}
+	o.FieldExpires[field] = when
+	m.hashTTLKeys[key] = struct{}{}
+}
+
+//clearFieldTTL removes the deadline of field, it returns false if there was none
+func (m *Memdb) clearFieldTTL(key string, o *Object, field string) bool {
+	if _, found := o.FieldExpires[field]; !found {
+		return false
+	}
+	delete(o.FieldExpires, field)
+	if len(o.FieldExpires) == 0 {
+		o.FieldExpires = nil
+		delete(m.hashTTLKeys, key)
+	}
+	return true
+}
+
+//expireHashFields removes the expired fields of the hash at key and the key
+//itself when no field is left, it returns true if the key was deleted.
+//Like expireIfNeeded the fields are kept when the hdel can't be logged.
+//The caller must hold the write lock.
+func (m *Memdb) expireHashFields(key string, o *Object) bool {
+	var expired [][]byte
+	for field := range o.FieldExpires {
+		if m.fieldExpired(o, field) {
+			expired = append(expired, []byte(field))
+		}
+	}
+	if len(expired) == 0 {
+		return false
+	}
+	//和expireIfNeeded一样，过期删除作为hdel记录在wal里
+	if err := m.save(&Opt{Method: "hdel", Key: key, Args: expired}); err != nil {
+		log.Printf("raft-redis: failed to expire fields of %q (%v)", key, err)
+		return false
+	}
+	for _, field := range expired {
+		delete(o.Hash, string(field))
+		m.clearFieldTTL(key, o, string(field))
+	}
+	if len(o.Hash) == 0 {
+		m.delKey(key)
+		return true
+	}
+	return false
+}
+
+//activeExpireHashFields samples hashes with field deadlines and removes their
+//expired fields, the caller must hold the write lock
+func (m *Memdb) activeExpireHashFields() {
+	sampled := 0
+	for key := range m.hashTTLKeys {
+		if sampled >= activeExpireCycleLookups {
+			return
+		}
+		sampled++
+		if o, found := m.keys[key]; found && o.Type == ObjHash {
+			m.expireHashFields(key, o)
+		} else {
+			delete(m.hashTTLKeys, key)
+		}
+	}
+}
+
+//HpexpireAt sets the deadline of fields in unix milliseconds when cond allows
+//it, fields whose deadline is already past are deleted
+func (m *Memdb) HpexpireAt(key string, when int64, cond int, fields [][]byte) ([]int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjHash)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]int, len(fields))
+	if o == nil {
+		for i := range ret {
+			ret[i] = hfeNoField
+		}
+		return ret, nil
+	}
+	past := when <= mstime() && !m.recovebool
+	var set, deleted [][]byte
+	for i, field := range fields {
+		if _, exists := o.Hash[string(field)]; !exists {
+			ret[i] = hfeNoField
+			continue
+		}
+		old, hasTTL := o.FieldExpires[string(field)]
+		if (cond&hexpireNX != 0 && hasTTL) || (cond&hexpireXX != 0 && !hasTTL) ||
+			(cond&hexpireGT != 0 && (!hasTTL || when <= old)) ||
+			(cond&hexpireLT != 0 && hasTTL && when >= old) {
+			ret[i] = hfeCondFail
+			continue
+		}
+		if past {
+			ret[i] = hfeDeleted
+			deleted = append(deleted, field)
+		} else {
+			ret[i] = hfeOk
+			set = append(set, field)
+		}
+	}
+	if !m.recovebool {
+		if len(deleted) > 0 {
+			err := m.save(&Opt{Method: "hdel", Key: key, Args: deleted})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(set) > 0 {
+			args := append([][]byte{[]byte(strconv.FormatInt(when, 10))}, set...)
+			err := m.save(&Opt{Method: "hpexpireat", Key: key, Args: args})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, field := range set {
+		m.setFieldTTL(key, o, string(field), when)
+	}
+	for _, field := range deleted {
+		delete(o.Hash, string(field))
+		m.clearFieldTTL(key, o, string(field))
+	}
+	if len(o.Hash) == 0 {
+		m.delKey(key)
+	}
+	return ret, nil
+}
+
+//Hpersist removes the deadline of fields
+func (m *Memdb) Hpersist(key string, fields [][]byte) ([]int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjHash)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]int, len(fields))
+	var persisted [][]byte
+	for i, field := range fields {
+		if o == nil {
+			ret[i] = hfeNoField
+		} else if _, exists := o.Hash[string(field)]; !exists {
+			ret[i] = hfeNoField
+		} else if _, hasTTL := o.FieldExpires[string(field)]; !hasTTL {
+			ret[i] = hfeNoTTL
+		} else {
+			ret[i] = hfeOk
+			persisted = append(persisted, field)
+		}
+	}
+	if len(persisted) == 0 {
+		return ret, nil
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "hpersist", Key: key, Args: persisted})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, field := range persisted {
+		m.clearFieldTTL(key, o, string(field))
+	}
+	return ret, nil
+}
+
+//HexpireTimes returns the deadline of fields in unix milliseconds, -2 for
+//the fields that do not exist and -1 for the fields without a deadline
+func (m *Memdb) HexpireTimes(key string, fields [][]byte) ([]int64, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjHash)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]int64, len(fields))
+	for i, field := range fields {
+		if o == nil || m.hashField(o, string(field)) == nil {
+			ret[i] = hfeNoField
+		} else if when, hasTTL := o.FieldExpires[string(field)]; hasTTL {
+			ret[i] = when
+		} else {
+			ret[i] = hfeNoTTL
+		}
+	}
+	return ret, nil
+}
+
+//hsetKeepTTL sets field like Hset but keeps its deadline, used by the wal
+//records of HINCRBY and HINCRBYFLOAT
+func (m *Memdb) hsetKeepTTL(key, field string, value []byte) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjHash)
+	if err != nil {
+		return
+	}
+	if o == nil {
+		o = newHashObject()
+		m.setKey(key, o)
+	}
+	o.Hash[field] = value
+}
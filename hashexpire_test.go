@@ -0,0 +1,157 @@
+package newredis
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHashFieldExpireCommands(t *testing.T) {
+	s := newTestServer()
+	at := strconv.FormatInt(mstime()/1000+1000, 10)
+	runCases(t, s, []cmdCase{
+		{"hset h a 1 b 2 c 3", ":3"},
+		{"hexpire h 100 fields 2 a nofield", "*2 :1 :-2"},
+		{"httl h fields 3 a b nofield", "*3 :100 :-1 :-2"},
+		{"hexpire h 200 nx fields 2 a b", "*2 :0 :1"},
+		{"hexpire h 300 xx fields 2 a c", "*2 :1 :0"},
+		{"hexpire h 50 gt fields 3 a b c", "*3 :0 :0 :0"},
+		{"hexpire h 400 gt fields 3 a b c", "*3 :1 :1 :0"},
+		{"hexpire h 10 lt fields 3 a b c", "*3 :1 :1 :1"},
+		{"httl h fields 3 a b c", "*3 :10 :10 :10"},
+		{"hpexpire h 5000 fields 1 a", "*1 :1"},
+		{"hpttl h fields 1 nofield", "*1 :-2"},
+		{"httl h fields 1 a", "*1 :5"},
+		{"hexpireat h " + at + " fields 1 a", "*1 :1"},
+		{"hexpiretime h fields 1 a", "*1 :" + at},
+		{"hpexpiretime h fields 1 a", "*1 :" + at + "000"},
+		{"hpersist h fields 3 a c nofield", "*3 :1 :1 :-2"},
+		{"hpersist h fields 1 a", "*1 :-1"},
+		{"httl h fields 3 a b c", "*3 :-1 :10 :-1"},
+		{"hset h b 5", ":0"},
+		{"httl h fields 1 b", "*1 :-1"},
+		{"hexpire h 0 fields 1 b", "*1 :2"},
+		{"hexists h b", ":0"},
+		{"hexpireat h 1 fields 2 a c", "*2 :2 :2"},
+		{"exists h", ":0"},
+		{"hexpire nokey 10 fields 1 a", "*1 :-2"},
+		{"httl nokey fields 1 a", "*1 :-2"},
+		{"hpersist nokey fields 1 a", "*1 :-2"},
+		{"hset h a 1", ":1"},
+		{"hexpire h -1 fields 1 a", "ERR invalid expire time, must be >= 0"},
+		{"hexpire h x fields 1 a", "ERR value is not an integer or out of range"},
+		{"hexpire h 99999999999999 fields 1 a", "ERR invalid expire time in 'hexpire' command"},
+		{"hexpire h 10 fields 0 a", "ERR Parameter `numFields` should be greater than 0"},
+		{"hexpire h 10 fields 2 a", "ERR The `numfields` parameter must match the number of arguments"},
+		{"hexpire h 10 nx xx fields 1 a", "ERR Mandatory argument FIELDS is missing or not at the right position"},
+		{"set s v", "+OK"},
+		{"hexpire s 10 fields 1 a", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{"httl s fields 1 a", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+}
+
+//TestHashFieldExpiry checks what the commands see once the deadline of some
+//fields has passed
+func TestHashFieldExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		expired []string //fields whose deadline is moved to the past
+		cases   []cmdCase
+	}{
+		{"reads hide expired fields", []string{"a"}, []cmdCase{
+			{"hget h a", "(nil)"},
+			{"hexists h a", ":0"},
+			{"hlen h", ":2"},
+			{"hmget h a b", "*2 (nil) $2"},
+			{"hstrlen h a", ":0"},
+			{"httl h fields 2 a b", "*2 :-2 :100"},
+			{"hset h c 4", ":0"},
+			{"hlen h", ":2"},
+		}},
+		{"writes remove expired fields", []string{"a", "b"}, []cmdCase{
+			{"hsetnx h a 9", ":1"},
+			{"hget h a", "$9"},
+			{"httl h fields 2 a b", "*2 :-1 :-2"},
+			{"hincrby h b 5", ":5"},
+			{"hmget h a b c", "*3 $9 $5 $3"},
+		}},
+		{"last field expired", []string{"a", "b", "c"}, []cmdCase{
+			{"hlen h", ":0"},
+			{"hset h x 1", ":1"},
+			{"hlen h", ":1"},
+		}},
+		{"hincrby keeps the deadline", nil, []cmdCase{
+			{"hincrby h a 5", ":6"},
+			{"hincrbyfloat h b 0.5", "$2.5"},
+			{"httl h fields 2 a b", "*2 :100 :100"},
+			{"hset h a 1", ":0"},
+			{"httl h fields 2 a b", "*2 :-1 :100"},
+		}},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		runCases(t, s, []cmdCase{
+			{"hset h a 1 b 2 c 3", ":3"},
+			{"hexpire h 100 fields 2 a b", "*2 :1 :1"},
+		})
+		o := s.dbs[0].keys["h"]
+		for _, field := range tt.expired {
+			o.FieldExpires[field] = mstime() - 1
+		}
+		c := &testConn{}
+		for _, tc := range tt.cases {
+			if got := do(s, c, tc.cmd); got != tc.want {
+				t.Errorf("%s: %s got %q, want %q", tt.name, tc.cmd, got, tc.want)
+			}
+		}
+		assertSameKeyspace(t, s, replay(t))
+	}
+}
+
+func TestActiveExpireHashFields(t *testing.T) {
+	s := newTestServer()
+	c := &testConn{}
+	db := s.dbs[0]
+	for i := 0; i < 10; i++ {
+		key := "h" + strconv.Itoa(i)
+		do(s, c, "hset "+key+" a 1 b 2")
+		do(s, c, "hexpire "+key+" 100 fields 2 a b")
+		db.keys[key].FieldExpires["a"] = mstime() - 1
+		if i%2 == 0 {
+			db.keys[key].FieldExpires["b"] = mstime() - 1
+		}
+	}
+	db.activeExpireCycle()
+	if len(db.keys) != 5 {
+		t.Errorf("%d hashes left, want 5", len(db.keys))
+	}
+	for key, o := range db.keys {
+		if len(o.Hash) != 1 || o.Hash["b"] == nil {
+			t.Errorf("%s holds %v", key, o.Hash)
+		}
+	}
+	if len(db.hashTTLKeys) != 5 {
+		t.Errorf("%d hashes tracked, want 5", len(db.hashTTLKeys))
+	}
+	assertSameKeyspace(t, s, replay(t))
+}
+
+//TestHashFieldExpireReplay checks that the deadlines logged are absolute
+func TestHashFieldExpireReplay(t *testing.T) {
+	s := newTestServer()
+	runCases(t, s, []cmdCase{
+		{"hset h a 1 b 2 c 3 d 4", ":4"},
+		{"hexpire h 100 fields 2 a b", "*2 :1 :1"},
+		{"hpexpire h 50000 gt fields 2 b c", "*2 :0 :0"},
+		{"hpexpire h 500000 gt fields 2 b c", "*2 :1 :0"},
+		{"hpersist h fields 1 a", "*1 :1"},
+		{"hexpire h 0 fields 1 d", "*1 :2"},
+		{"hincrby h b 1", ":3"},
+		{"copy h h2", ":1"},
+		{"rename h h3", "+OK"},
+	})
+	r := replay(t)
+	assertSameKeyspace(t, s, r)
+	if len(r.dbs[0].hashTTLKeys) != 2 {
+		t.Errorf("%d hashes tracked after replay, want 2", len(r.dbs[0].hashTTLKeys))
+	}
+}
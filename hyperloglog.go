@@ -0,0 +1,473 @@
+package newredis
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+//HyperLogLog values are plain strings using the same layout as redis, so they
+//can be moved between newredis and redis with GET and SET:
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+//4 bytes magic, 1 byte encoding (dense or sparse), 3 unused bytes and the
+//cached cardinality as 64 bit little endian, the most significant bit set
+//means the cache is invalid. The registers follow the header.
+//
+//Dense: 16384 registers of 6 bits, packed starting from the least
+//significant bit of each byte.
+//
+//Sparse: run length encoded registers with three opcodes
+//	00xxxxxx          ZERO  xxxxxx+1 registers set to 0
+//	01xxxxxx yyyyyyyy XZERO xxxxxxyyyyyyyy+1 registers set to 0
+//	1vvvvvxx          VAL   xx+1 registers set to vvvvv+1
+const (
+	hllP          = 14
+	hllQ          = 64 - hllP
+	hllRegisters  = 1 << hllP
+	hllPMask      = hllRegisters - 1
+	hllBits       = 6
+	hllRegMax     = 1<<hllBits - 1
+	hllHdrSize    = 16
+	hllDenseSize  = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllDense      = 0
+	hllSparse     = 1
+	hllAlphaInf   = 0.721347520444481703680
+	hllSparseMaxBytes   = 3000
+	hllSparseValMax     = 32
+	hllSparseValMaxLen  = 4
+	hllSparseZeroMaxLen = 64
+	hllSparseXZeroMaxLen = 16384
+)
+
+var (
+	ErrNotHLL       = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrHLLCorrupted = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+type hllRegs [hllRegisters]uint8
+
+//murmurHash64A is the 64 bit MurmurHash2 redis uses to hash HLL elements
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(key)) * m)
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+//hllPatLen returns the register of ele and the length of the 000..1 pattern
+//of its hash, which is the value to store in the register
+func hllPatLen(ele []byte) (int, uint8) {
+	hash := murmurHash64A(ele, 0xadc83b19)
+	index := int(hash & hllPMask)
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+func hllDenseGet(p []byte, regnum int) uint8 {
+	b := regnum * hllBits / 8
+	fb := uint(regnum * hllBits & 7)
+	v := p[b] >> fb
+	if b+1 < len(p) {
+		v |= p[b+1] << (8 - fb)
+	}
+	return v & hllRegMax
+}
+
+func hllDenseSet(p []byte, regnum int, v uint8) {
+	b := regnum * hllBits / 8
+	fb := uint(regnum * hllBits & 7)
+	p[b] &^= hllRegMax << fb
+	p[b] |= v << fb
+	if b+1 < len(p) {
+		p[b+1] &^= hllRegMax >> (8 - fb)
+		p[b+1] |= v >> (8 - fb)
+	}
+}
+
+//isHLL checks the header of p, the same checks redis does before using a string as HLL
+func isHLL(p []byte) bool {
+	if len(p) < hllHdrSize || string(p[:4]) != "HYLL" || p[4] > hllSparse {
+		return false
+	}
+	return p[4] != hllDense || len(p) == hllDenseSize
+}
+
+func hllInvalidateCache(p []byte) {
+	p[15] |= 1 << 7
+}
+
+func hllCachedCard(p []byte) (uint64, bool) {
+	if p[15]&(1<<7) != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(p[8:16]), true
+}
+
+func hllSetCachedCard(p []byte, card uint64) {
+	binary.LittleEndian.PutUint64(p[8:16], card)
+}
+
+//hllDecode reads the registers of a valid HLL string
+func hllDecode(p []byte, regs *hllRegs) error {
+	if p[4] == hllDense {
+		for i := 0; i < hllRegisters; i++ {
+			regs[i] = hllDenseGet(p[hllHdrSize:], i)
+		}
+		return nil
+	}
+	idx := 0
+	for _, op := range sparseOps(p[hllHdrSize:]) {
+		if idx+op.runlen > hllRegisters {
+			return ErrHLLCorrupted
+		}
+		for i := 0; i < op.runlen; i++ {
+			regs[idx+i] = op.value
+		}
+		idx += op.runlen
+	}
+	if idx != hllRegisters {
+		return ErrHLLCorrupted
+	}
+	return nil
+}
+
+type sparseOp struct {
+	value  uint8
+	runlen int
+}
+
+func sparseOps(p []byte) []sparseOp {
+	var ops []sparseOp
+	for i := 0; i < len(p); i++ {
+		switch {
+		case p[i]&0xc0 == 0:
+			ops = append(ops, sparseOp{0, int(p[i]&0x3f) + 1})
+		case p[i]&0xc0 == 0x40:
+			if i+1 >= len(p) {
+				//被截断的XZERO，长度对不上，hllDecode会报错
+				ops = append(ops, sparseOp{0, hllRegisters + 1})
+				return ops
+			}
+			ops = append(ops, sparseOp{0, int(p[i]&0x3f)<<8 | int(p[i+1]) + 1})
+			i++
+		default:
+			ops = append(ops, sparseOp{(p[i]>>2)&0x1f + 1, int(p[i]&0x3) + 1})
+		}
+	}
+	return ops
+}
+
+//hllEncode builds an HLL string holding regs with an invalid cardinality
+//cache, sparse when possible
+func hllEncode(regs *hllRegs) []byte {
+	p := make([]byte, hllHdrSize, hllDenseSize)
+	copy(p, "HYLL")
+	hllInvalidateCache(p)
+	p[4] = hllSparse
+	for i := 0; i < hllRegisters; {
+		v := regs[i]
+		if v > hllSparseValMax {
+			return hllEncodeDense(regs)
+		}
+		run := 1
+		for i+run < hllRegisters && regs[i+run] == v {
+			run++
+		}
+		i += run
+		for run > 0 {
+			switch {
+			case v != 0:
+				n := run
+				if n > hllSparseValMaxLen {
+					n = hllSparseValMaxLen
+				}
+				p = append(p, 0x80|(v-1)<<2|byte(n-1))
+				run -= n
+			case run > hllSparseZeroMaxLen:
+				n := run
+				if n > hllSparseXZeroMaxLen {
+					n = hllSparseXZeroMaxLen
+				}
+				p = append(p, 0x40|byte((n-1)>>8), byte(n-1))
+				run -= n
+			default:
+				p = append(p, byte(run-1))
+				run = 0
+			}
+		}
+		if len(p) > hllSparseMaxBytes {
+			return hllEncodeDense(regs)
+		}
+	}
+	return p
+}
+
+func hllEncodeDense(regs *hllRegs) []byte {
+	p := make([]byte, hllDenseSize)
+	copy(p, "HYLL")
+	hllInvalidateCache(p)
+	p[4] = hllDense
+	for i, v := range regs {
+		hllDenseSet(p[hllHdrSize:], i, v)
+	}
+	return p
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			break
+		}
+	}
+	return z / 3
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			break
+		}
+	}
+	return z
+}
+
+//hllCount estimates the cardinality with the improved estimator by Otmar Ertl,
+//the same one redis uses
+func hllCount(regs *hllRegs) uint64 {
+	var histo [64]int
+	for _, v := range regs {
+		histo[v]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+//lookupHLL returns the HLL string at key, nil if the key does not exist
+func (m *Memdb) lookupHLL(key string) (*Object, error) {
+	o, err := m.lookupRead(key, ObjString)
+	if err != nil {
+		return nil, err
+	}
+	if o != nil && !isHLL(o.Str) {
+		return nil, ErrNotHLL
+	}
+	return o, nil
+}
+
+//hllUpdate is a register PFADD raises
+type hllUpdate struct {
+	index int
+	count uint8
+}
+
+//Pfadd adds elements to the HLL at key, it returns 1 if the key was created
+//or at least one register changed. Dense HLLs are updated in place, sparse
+//ones are decoded and encoded again, possibly as dense.
+func (m *Memdb) Pfadd(key string, elements ...[]byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	m.expireIfNeeded(key)
+	o, err := m.lookupHLL(key)
+	if err != nil {
+		return 0, err
+	}
+	var regs hllRegs
+	var dense []byte
+	if o != nil && o.Str[4] == hllDense {
+		dense = o.Str[hllHdrSize:]
+	} else if o != nil {
+		if err := hllDecode(o.Str, &regs); err != nil {
+			return 0, err
+		}
+	}
+	//先找出要修改的寄存器，wal写成功之后再改
+	var updates []hllUpdate
+	for _, ele := range elements {
+		index, count := hllPatLen(ele)
+		old := regs[index]
+		if dense != nil {
+			old = hllDenseGet(dense, index)
+		}
+		if count > old {
+			updates = append(updates, hllUpdate{index, count})
+		}
+	}
+	if o != nil && len(updates) == 0 {
+		return 0, nil
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "pfadd", Key: key, Args: elements})
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, u := range updates {
+		if dense != nil {
+			if u.count > hllDenseGet(dense, u.index) {
+				hllDenseSet(dense, u.index, u.count)
+			}
+		} else if u.count > regs[u.index] {
+			regs[u.index] = u.count
+		}
+	}
+	switch {
+	case dense != nil:
+		hllInvalidateCache(o.Str)
+	case o != nil:
+		o.Str = hllEncode(&regs)
+	default:
+		o = newStringObject(hllEncode(&regs))
+		if len(updates) == 0 {
+			//和redis一样，新建的空HLL缓存的基数是有效的0
+			hllSetCachedCard(o.Str, 0)
+		}
+		m.setKey(key, o)
+	}
+	return 1, nil
+}
+
+//Pfcount returns the estimated cardinality of the HLL at key, or of the union
+//of several keys. With a single key the result is cached in the HLL header.
+func (m *Memdb) Pfcount(keys ...string) (uint64, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	var regs hllRegs
+	if len(keys) == 1 {
+		o, err := m.lookupHLL(keys[0])
+		if o == nil {
+			return 0, err
+		}
+		if card, ok := hllCachedCard(o.Str); ok {
+			return card, nil
+		}
+		if err := hllDecode(o.Str, &regs); err != nil {
+			return 0, err
+		}
+		//缓存只是内存里的优化，回放时会重新计算，不写wal
+		card := hllCount(&regs)
+		hllSetCachedCard(o.Str, card)
+		return card, nil
+	}
+	if err := m.hllUnion(&regs, keys); err != nil {
+		return 0, err
+	}
+	return hllCount(&regs), nil
+}
+
+//hllUnion merges the registers of the HLLs at keys into regs, missing keys are skipped
+func (m *Memdb) hllUnion(regs *hllRegs, keys []string) error {
+	var tmp hllRegs
+	for _, key := range keys {
+		o, err := m.lookupHLL(key)
+		if err != nil {
+			return err
+		}
+		if o == nil {
+			continue
+		}
+		if err := hllDecode(o.Str, &tmp); err != nil {
+			return err
+		}
+		for i, v := range tmp {
+			if v > regs[i] {
+				regs[i] = v
+			}
+		}
+	}
+	return nil
+}
+
+//Pfmerge stores into dest the union of dest and the HLLs at keys, dest stays
+//sparse unless one of the inputs is dense
+func (m *Memdb) Pfmerge(dest string, keys ...string) error {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	m.expireIfNeeded(dest)
+	var regs hllRegs
+	dense := false
+	for _, key := range append([]string{dest}, keys...) {
+		o, err := m.lookupHLL(key)
+		if err != nil {
+			return err
+		}
+		if o != nil && o.Str[4] == hllDense {
+			dense = true
+		}
+	}
+	if err := m.hllUnion(&regs, append([]string{dest}, keys...)); err != nil {
+		return err
+	}
+	if !m.recovebool {
+		args := make([][]byte, len(keys))
+		for i, key := range keys {
+			args[i] = []byte(key)
+		}
+		err := m.save(&Opt{Method: "pfmerge", Key: dest, Args: args})
+		if err != nil {
+			return err
+		}
+	}
+	var p []byte
+	if dense {
+		p = hllEncodeDense(&regs)
+	} else {
+		p = hllEncode(&regs)
+	}
+	if o, found := m.keys[dest]; found {
+		o.Str = p
+	} else {
+		m.setKey(dest, newStringObject(p))
+	}
+	return nil
+}
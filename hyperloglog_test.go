@@ -0,0 +1,174 @@
+package newredis
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestHLLEncodeDecode(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name  string
+		fill  func(regs *hllRegs)
+		dense bool
+	}{
+		{"empty", func(regs *hllRegs) {}, false},
+		{"first and last", func(regs *hllRegs) { regs[0], regs[hllRegisters-1] = 1, 2 }, false},
+		{"long runs", func(regs *hllRegs) {
+			for i := 100; i < 200; i++ {
+				regs[i] = 7
+			}
+		}, false},
+		{"max sparse value", func(regs *hllRegs) { regs[5] = hllSparseValMax }, false},
+		{"value too big for sparse", func(regs *hllRegs) { regs[5] = hllSparseValMax + 1 }, true},
+		{"too many bytes for sparse", func(regs *hllRegs) {
+			for i := range regs {
+				regs[i] = uint8(rnd.Intn(4))
+			}
+		}, true},
+		{"all max", func(regs *hllRegs) {
+			for i := range regs {
+				regs[i] = hllRegMax
+			}
+		}, true},
+	}
+	for _, tt := range tests {
+		var regs, got hllRegs
+		tt.fill(&regs)
+		p := hllEncode(&regs)
+		if !isHLL(p) {
+			t.Errorf("%s: encoded value is not an HLL", tt.name)
+			continue
+		}
+		if dense := p[4] == hllDense; dense != tt.dense {
+			t.Errorf("%s: dense %v, want %v", tt.name, dense, tt.dense)
+		}
+		if p[4] == hllSparse && len(p) > hllHdrSize+hllSparseMaxBytes {
+			t.Errorf("%s: sparse value of %d bytes", tt.name, len(p))
+		}
+		if err := hllDecode(p, &got); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if got != regs {
+			t.Errorf("%s: registers changed by encoding", tt.name)
+		}
+		if err := hllDecode(hllEncodeDense(&regs), &got); err != nil || got != regs {
+			t.Errorf("%s: dense round trip failed (%v)", tt.name, err)
+		}
+	}
+}
+
+func TestHLLDecodeCorrupted(t *testing.T) {
+	header := func(body ...byte) []byte {
+		return append([]byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80"), body...)
+	}
+	tests := []struct {
+		name string
+		p    []byte
+	}{
+		{"too few registers", header(0x3f)},
+		{"too many registers", header(0x7f, 0xff, 0x00)},
+		{"truncated xzero", header(0x7f)},
+	}
+	for _, tt := range tests {
+		var regs hllRegs
+		if err := hllDecode(tt.p, &regs); err != ErrHLLCorrupted {
+			t.Errorf("%s: error %v, want %v", tt.name, err, ErrHLLCorrupted)
+		}
+	}
+}
+
+//TestHLLPromotion adds elements one at a time, the HLL must turn dense at some
+//point and its estimate must match the one of the same registers kept dense
+func TestHLLPromotion(t *testing.T) {
+	s := newTestServer()
+	db := s.dbs[0]
+	const n = 20000
+	promoted := 0
+	for i := 0; i < n; i++ {
+		if _, err := db.Pfadd("hll", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+		if promoted == 0 && db.keys["hll"].Str[4] == hllDense {
+			promoted = i + 1
+		}
+	}
+	if promoted == 0 {
+		t.Fatalf("still sparse after %d elements", n)
+	}
+	t.Logf("promoted to dense after %d elements", promoted)
+	//the cached cardinality isn't logged, compare before PFCOUNT sets it
+	assertSameKeyspace(t, s, replay(t))
+	card, err := db.Pfcount("hll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := math.Abs(float64(card)-n) / n; e > 0.02 {
+		t.Errorf("estimate %d for %d elements", card, n)
+	}
+}
+
+func TestHLLCommands(t *testing.T) {
+	s := newTestServer()
+	runCases(t, s, []cmdCase{
+		{"pfadd h", ":1"},
+		{"pfadd h", ":0"},
+		{"pfcount h", ":0"},
+		{"pfadd h a b c", ":1"},
+		{"pfadd h a b c", ":0"},
+		{"pfcount h", ":3"},
+		{"pfadd h2 c d", ":1"},
+		{"pfcount h h2 nokey", ":4"},
+		{"pfmerge h3 h h2", "+OK"},
+		{"pfcount h3", ":4"},
+		{"pfcount nokey", ":0"},
+		{"set s foo", "+OK"},
+		{"pfadd s a", "WRONGTYPE Key is not a valid HyperLogLog string value."},
+		{"pfcount s", "WRONGTYPE Key is not a valid HyperLogLog string value."},
+		{"pfmerge h3 s", "WRONGTYPE Key is not a valid HyperLogLog string value."},
+		{"rpush l a", ":1"},
+		{"pfadd l a", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+	//PFCOUNT caches the cardinality without logging it
+	r := replay(t)
+	runCases(t, r, []cmdCase{
+		{"pfcount h", ":3"},
+		{"pfcount h3", ":4"},
+	})
+	assertSameKeyspace(t, s, r)
+}
+
+//TestPfaddInvalidNotLogged checks that a PFADD failing on the existing value
+//doesn't reach the wal
+func TestPfaddInvalidNotLogged(t *testing.T) {
+	s := newTestServer()
+	db := s.dbs[0]
+	corrupted := append([]byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80"), 0x3f)
+	if _, _, err := db.SetGeneric("bad", corrupted, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.SetGeneric("str", []byte("foo"), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	logged := len(ents)
+	tests := []struct {
+		key string
+		err error
+	}{
+		{"bad", ErrHLLCorrupted},
+		{"str", ErrNotHLL},
+	}
+	for _, tt := range tests {
+		if _, err := db.Pfadd(tt.key, []byte("a")); err != tt.err {
+			t.Errorf("pfadd %s: error %v, want %v", tt.key, err, tt.err)
+		}
+	}
+	if len(ents) != logged {
+		t.Errorf("%d wal entries written by failed PFADDs", len(ents)-logged)
+	}
+	if string(db.keys["bad"].Str) != string(corrupted) {
+		t.Errorf("corrupted value modified")
+	}
+	assertSameKeyspace(t, s, replay(t))
+}
@@ -0,0 +1,227 @@
+package newredis
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/rand"
+
+	"github.com/widaT/newredis/structure"
+)
+
+//keyIndex keeps every key ordered by the 32 bit hash of its name.
+//SCAN uses the hash as cursor: the position of a key never moves, so a key
+//that exists during the whole iteration is always returned, no matter how
+//many keys are added or removed between two calls.
+func keyHash(key string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return float64(h.Sum32())
+}
+
+func (m *Memdb) addKey(key string) {
+	m.keyIndex.Set(keyHash(key), key)
+}
+
+func (m *Memdb) removeKey(key string) {
+	m.keyIndex.Delete(keyHash(key), key)
+}
+
+func (m *Memdb) rebuildKeyIndex() {
+	m.keyIndex = structure.NewSkipList()
+	m.hashTTLKeys = make(map[string]struct{})
+	for key, o := range m.keys {
+		m.addKey(key)
+		if o.FieldExpires != nil {
+			m.hashTTLKeys[key] = struct{}{}
+		}
+	}
+}
+
+//keyType returns the type name of key as reported by TYPE
+func (m *Memdb) keyType(key string) string {
+	o, found := m.keys[key]
+	if !found {
+		return "none"
+	}
+	return o.Type.String()
+}
+
+func (m *Memdb) Exists(keys ...[]byte) (int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	count := 0
+	for _, k := range keys {
+		key := string(k)
+		if !m.expired(key) && m.exists(key) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Memdb) Type(key string) (string, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	if m.expired(key) {
+		return "none", nil
+	}
+	return m.keyType(key), nil
+}
+
+//ObjectEncoding returns the encoding of the value at key, "" if the key does not exist
+func (m *Memdb) ObjectEncoding(key string) (string, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	if m.expired(key) {
+		return "", nil
+	}
+	o, found := m.keys[key]
+	if !found {
+		return "", nil
+	}
+	return o.encoding(), nil
+}
+
+func (m *Memdb) Dbsize() (int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	return m.keyIndex.Len(), nil
+}
+
+func (m *Memdb) Keys(pattern []byte) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	var ret [][]byte
+	for iter := m.keyIndex.Iterator(); iter.Next(); {
+		key := iter.Value()
+		if m.expired(key) || !stringMatch(pattern, []byte(key), false) {
+			continue
+		}
+		ret = append(ret, []byte(key))
+	}
+	return ret, nil
+}
+
+//Scan visits about count keys starting from cursor and returns the next cursor,
+//0 when the iteration is complete. Keys sharing a hash are never split between
+//two calls. An empty pattern or typ disables the filter.
+func (m *Memdb) Scan(cursor uint64, count int, pattern []byte, typ string) (uint64, [][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	var ret [][]byte
+	if cursor > math.MaxUint32 {
+		return 0, ret, nil
+	}
+	visited := 0
+	last := float64(-1)
+	iter := m.keyIndex.Range(float64(cursor), math.MaxUint32)
+	defer iter.Close()
+	for iter.Next() {
+		if visited >= count && iter.Key() != last {
+			return uint64(last) + 1, ret, nil
+		}
+		visited++
+		last = iter.Key()
+		key := iter.Value()
+		if m.expired(key) {
+			continue
+		}
+		if len(pattern) > 0 && !stringMatch(pattern, []byte(key), false) {
+			continue
+		}
+		if typ != "" && m.keyType(key) != typ {
+			continue
+		}
+		ret = append(ret, []byte(key))
+	}
+	return 0, ret, nil
+}
+
+func (m *Memdb) RandomKey() ([]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	if m.keyIndex.Len() == 0 {
+		return nil, nil
+	}
+	//从随机位置开始找第一个没有过期的key，到末尾后从头开始
+	iter := m.keyIndex.Range(float64(rand.Uint32()), math.MaxUint32)
+	for iter.Next() {
+		if !m.expired(iter.Value()) {
+			return []byte(iter.Value()), nil
+		}
+	}
+	for iter = m.keyIndex.Iterator(); iter.Next(); {
+		if !m.expired(iter.Value()) {
+			return []byte(iter.Value()), nil
+		}
+	}
+	return nil, nil
+}
+
+var ErrNoSuchKey = errors.New("ERR no such key")
+
+//Rename renames src to dst carrying its deadline over, with nx it does nothing
+//and returns 0 when dst already exists
+func (m *Memdb) Rename(src, dst string, nx bool) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	m.expireIfNeeded(src)
+	m.expireIfNeeded(dst)
+	o, found := m.keys[src]
+	if !found {
+		return 0, ErrNoSuchKey
+	}
+	if src == dst {
+		if nx {
+			return 0, nil
+		}
+		return 1, nil
+	}
+	if nx && m.exists(dst) {
+		return 0, nil
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "rename", Key: src, Args: [][]byte{[]byte(dst)}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	when, hasExpire := m.Expires[src]
+	m.delKey(src)
+	m.delKey(dst)
+	m.setKey(dst, o)
+	if hasExpire {
+		m.Expires[dst] = when
+	}
+	m.serveBlocked(dst)
+	return 1, nil
+}
+
+//Unlink removes keys like Del, the values are released by a background
+//goroutine so big collections don't hold the lock while they are freed
+func (m *Memdb) Unlink(keys ...[]byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "del", Args: keys})
+		if err != nil {
+			return 0, err
+		}
+	}
+	unlinked := make(map[string]*Object)
+	for _, k := range keys {
+		key := string(k)
+		if m.expireIfNeeded(key) {
+			continue
+		}
+		if o, exists := m.keys[key]; exists {
+			m.delKey(key)
+			unlinked[key] = o
+		}
+	}
+	if len(unlinked) > 0 {
+		go freeObjects(unlinked)
+	}
+	return len(unlinked), nil
+}
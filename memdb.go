@@ -3,10 +3,12 @@ package newredis
 import (
 	"sync"
 	"github.com/widaT/newredis/structure"
-	"fmt"
-	"strings"
 	"errors"
 	"strconv"
+	"math"
+	"math/big"
+	"sort"
+	"math/rand"
 	"github.com/vmihailenco/msgpack"
 )
 
@@ -15,40 +17,57 @@ type (
 	HashFloat     map[string]float64
 	HashHash    map[string]HashValue
 	HashHashInt map[string]HashFloat
-	HashBrStack map[string]*structure.List
-	HashSkipList map[string]*structure.SkipList
-	HashSet     map[string]*structure.Set
 	HashList    map[string][][]byte
 )
 
+const (
+	setNX = 1 << iota
+	setXX
+	setKeepTTL
+	setGet
+)
+
+var (
+	ErrWrongType  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+	ErrNotFloat   = errors.New("ERR value is not a valid float")
+	ErrOverflow   = errors.New("ERR increment or decrement would overflow")
+	ErrNaNOrInf   = errors.New("ERR increment would produce NaN or Infinity")
+	ErrOffsetOutOfRange = errors.New("ERR offset is out of range")
+	ErrStringTooLong    = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	ErrHashNotInteger   = errors.New("ERR hash value is not an integer")
+	ErrHashNotFloat     = errors.New("ERR hash value is not a float")
+)
+
 type Memdb struct {
-	Values  HashValue
-	Hvalues HashHash
-	dlList HashBrStack
-	HSet HashSet
-	HList HashList
-	HSortSet HashHashInt
-	skiplist HashSkipList
+	index int //数据库编号
+	keys map[string]*Object
+	Expires map[string]int64 //key的过期时间，unix毫秒
+	keyIndex *structure.SkipList //所有key按hash排序，给scan用
 	rwmu sync.RWMutex
 	recovebool bool   //初始化的时候不重复写wal
 	s *Server
+	blocked map[string][]*blockedClient //阻塞在每个key上的客户端，先进先出
+	hashTTLKeys map[string]struct{} //有字段设置了过期时间的hash，给主动过期用
+	setMaxIntsetEntries int //整数集合超过这个数量转成hashtable
 }
 
-func NewMemdb(s *Server) *Memdb {
+func NewMemdb(s *Server, index int) *Memdb {
 	db := &Memdb{
-		Values:   make(HashValue),
-		dlList:  make(HashBrStack),
-		HSet    :  make(HashSet),
-		HSortSet    :  make(HashHashInt),
-		HList    :  make(HashList),
-		Hvalues :make(HashHash),
-		skiplist : make(HashSkipList),
+		index: index,
+		keys:   make(map[string]*Object),
+		Expires : make(map[string]int64),
+		keyIndex : structure.NewSkipList(),
 		s:s,
+		blocked: make(map[string][]*blockedClient),
+		hashTTLKeys: make(map[string]struct{}),
+		setMaxIntsetEntries: s.conf.setMaxIntsetEntries,
 	}
 	return db
 }
 
 type Opt struct {
+	Db     int
 	Method string
 	Key  string
 	Args   [][]byte
@@ -58,47 +77,161 @@ func (o *Opt)String() string  {
 	return  o.Method + o.Key
 }
 
-func (m *Memdb) getSnapshot()  ([]byte, error) {
-	b,err := msgpack.Marshal(m)
-	if err != nil {
-		return nil,err
+const snapshotVersion = 1
+
+type memdbSnapshot struct {
+	Version int
+	Keys    map[string]*snapshotObject
+	Expires map[string]int64
+}
+
+//legacySnapshot is the layout written before the keyspace was unified,
+//every type lived in its own map
+type legacySnapshot struct {
+	Values   HashValue
+	Hvalues  HashHash
+	HSet     map[string]struct {
+		Mset map[string]struct{}
+	}
+	HList    HashList
+	HSortSet HashHashInt
+	Expires  map[string]int64
+}
+
+func (m *Memdb) snapshot() *memdbSnapshot {
+	snap := &memdbSnapshot{
+		Version: snapshotVersion,
+		Keys:    make(map[string]*snapshotObject, len(m.keys)),
+		Expires: m.Expires,
 	}
-	List  := m.dlList
-	m.HList = make(HashList)
-	for key,v:= range List {
-		m.HList[key] = v.Values()
+	for key, o := range m.keys {
+		snap.Keys[key] = o.snapshot()
 	}
-	m.HList = nil
-	return b,nil
+	return snap
 }
 
-func (m *Memdb)  recoverFromSnapshot(snapshot []byte) error {
-	var db Memdb
+func (m *Memdb) restore(snap *memdbSnapshot) {
+	m.keys = make(map[string]*Object, len(snap.Keys))
+	for key, so := range snap.Keys {
+		if o := so.object(key, m.setMaxIntsetEntries); o != nil {
+			m.keys[key] = o
+		}
+	}
+	m.Expires = snap.Expires
+	if m.Expires == nil {
+		m.Expires = make(map[string]int64)
+	}
+	m.rebuildKeyIndex()
+}
 
-	if err := msgpack.Unmarshal(snapshot,&db); err != nil {
-		return err
+//decodeMemdbSnapshot reads the snapshot of a single database, old multi-map
+//snapshots are migrated to the keyspace layout
+func decodeMemdbSnapshot(b []byte, setMaxIntsetEntries int) (*memdbSnapshot, error) {
+	var snap memdbSnapshot
+	if err := msgpack.Unmarshal(b,&snap); err != nil {
+		return nil, err
 	}
-	db.dlList = make(HashBrStack)
-	for key,v := range db.HList {
-		if _,found := db.dlList[key];!found {
-			db.dlList[key] = structure.NewList()
+	if snap.Version == 0 {
+		var legacy legacySnapshot
+		if err := msgpack.Unmarshal(b,&legacy); err != nil {
+			return nil, err
 		}
-		db.dlList[key].Add(v...)
+		snap = legacy.migrate(setMaxIntsetEntries)
 	}
-	db.HList = nil
+	return &snap, nil
+}
 
-	db.skiplist = make(HashSkipList)
-	//重新初始化skiplist
-	for key,val := range db.HSortSet {
-		intmap := structure.NewSkipList()
-		for k,v := range val {
-			intmap.Set(v,k)
+//migrate converts the old multi-map layout, when the same key was stored in
+//more than one map the first of string, hash, list, set and zset wins
+func (l *legacySnapshot) migrate(setMaxIntsetEntries int) memdbSnapshot {
+	snap := memdbSnapshot{
+		Version: snapshotVersion,
+		Keys:    make(map[string]*snapshotObject),
+		Expires: l.Expires,
+	}
+	add := func(key string, so *snapshotObject) {
+		if _, found := snap.Keys[key]; !found {
+			snap.Keys[key] = so
 		}
-		db.skiplist[key] = intmap
 	}
-	db.s = m.s
-	*m = db
-	return nil
+	for key, v := range l.Values {
+		add(key, &snapshotObject{Type: ObjString, Str: v})
+	}
+	for key, v := range l.Hvalues {
+		if len(v) > 0 {
+			add(key, &snapshotObject{Type: ObjHash, Hash: v})
+		}
+	}
+	for key, v := range l.HList {
+		if len(v) > 0 {
+			add(key, &snapshotObject{Type: ObjList, List: v})
+		}
+	}
+	for key, v := range l.HSet {
+		if len(v.Mset) > 0 {
+			//按成员重新选择编码
+			o := newSetObject(key, setMaxIntsetEntries)
+			for member := range v.Mset {
+				o.Set.Add(member)
+			}
+			add(key, o.snapshot())
+		}
+	}
+	for key, v := range l.HSortSet {
+		if len(v) > 0 {
+			add(key, &snapshotObject{Type: ObjZset, Zset: v})
+		}
+	}
+	for key := range snap.Expires {
+		if _, found := snap.Keys[key]; !found {
+			delete(snap.Expires, key)
+		}
+	}
+	return snap
+}
+
+//save writes opt to the wal tagged with the index of this database
+func (m *Memdb) save(opt *Opt) error {
+	opt.Db = m.index
+	return m.s.w.save(opt)
+}
+
+//lookupRead returns the object stored at key, nil if the key does not exist
+//or ErrWrongType if it holds another type
+func (m *Memdb) lookupRead(key string, t ObjectType) (*Object, error) {
+	if m.expired(key) {
+		return nil, nil
+	}
+	o, found := m.keys[key]
+	if !found {
+		return nil, nil
+	}
+	if o.Type != t {
+		return nil, ErrWrongType
+	}
+	return o, nil
+}
+
+//lookupWrite is like lookupRead but removes the key first if it is expired,
+//the caller must hold the write lock
+func (m *Memdb) lookupWrite(key string, t ObjectType) (*Object, error) {
+	m.expireIfNeeded(key)
+	o, err := m.lookupRead(key, t)
+	if o != nil && o.FieldExpires != nil && m.expireHashFields(key, o) {
+		return nil, nil
+	}
+	return o, err
+}
+
+//setKey stores o at key replacing any old value, the deadline is kept
+func (m *Memdb) setKey(key string, o *Object) {
+	if _, found := m.keys[key]; !found {
+		m.addKey(key)
+	}
+	m.keys[key] = o
+	if o.FieldExpires != nil {
+		m.hashTTLKeys[key] = struct{}{}
+	}
 }
 
 //list operation
@@ -106,42 +239,54 @@ func (m *Memdb) Rpush(values ...[]byte) (int, error) {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
 	key := string(values[0])
-	if _, exists := m.dlList[key]; !exists {
-		m.dlList[key] =structure.NewList()
+	o, err := m.lookupWrite(key, ObjList)
+	if err != nil {
+		return 0, err
 	}
 	if !m.recovebool {
-		err := m.s.w.save(&Opt{Method:"rpush",Args:values})
+		err := m.save(&Opt{Method:"rpush",Args:values})
 		if err != nil {
 			return 0,err
 		}
 	}
-	n := m.dlList[key].Rpush(values[1:]...)
+	if o == nil {
+		o = newListObject()
+		m.setKey(key, o)
+	}
+	n := o.List.Rpush(values[1:]...)
+	m.serveBlocked(key)
 	return n, nil
 }
 
 func (m *Memdb) Lrange(key string, start, stop int) (*[][]byte, error) {
 	m.rwmu.RLock()
 	defer m.rwmu.RUnlock()
-
-	if _, exists := m.dlList[key]; !exists {
-		m.dlList[key] = structure.NewList()
+	ret := [][]byte{}
+	o, err := m.lookupRead(key, ObjList)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return &ret, nil
 	}
 
 	if start < 0 {
-		if start = m.dlList[key].Size() + start; start < 0 {
+		if start = o.List.Size() + start; start < 0 {
 			start = 0
 		}
 	}
 
-	var ret [][]byte
 	if stop < 0 {
-		stop =  m.dlList[key].Size() + stop
+		stop =  o.List.Size() + stop
 		if stop <0 {
-			return nil,nil
+			return &ret,nil
 		}
 	}
-	var iter = m.dlList[key].Seek(start)
-	if iter != nil {
+	var iter = o.List.Seek(start)
+	if iter == nil {
+		return &ret, nil
+	}
+	if start <= stop {
 		ret = append(ret, iter.Value())
 	}
 	for iter.Next(){
@@ -158,10 +303,14 @@ func (m *Memdb) Lrange(key string, start, stop int) (*[][]byte, error) {
 func (m *Memdb) Lindex(key string, index int) ([]byte, error) {
 	m.rwmu.RLock()
 	defer m.rwmu.RUnlock()
-	if _, exists := m.dlList[key]; !exists {
-		m.dlList[key] =  structure.NewList()
+	o, err := m.lookupRead(key, ObjList)
+	if o == nil {
+		return nil, err
 	}
-	ret,_ := m.dlList[key].Get(index)
+	if index < 0 {
+		index += o.List.Size()
+	}
+	ret,_ := o.List.Get(index)
 	return ret, nil
 }
 
@@ -169,451 +318,1996 @@ func (m *Memdb) Lpush(values ...[]byte) (int, error) {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
 	key := string(values[0])
-	if _, exists := m.dlList[key]; !exists {
-		m.dlList[key] = structure.NewList()
+	o, err := m.lookupWrite(key, ObjList)
+	if err != nil {
+		return 0, err
 	}
 	if !m.recovebool {
-		err := m.s.w.save(&Opt{Method: "lpush", Args: values})
+		err := m.save(&Opt{Method: "lpush", Args: values})
 		if err != nil {
 			return 0, err
 		}
 	}
-	num := m.dlList[key].Lpush(values[1:]...)
+	if o == nil {
+		o = newListObject()
+		m.setKey(key, o)
+	}
+	num := o.List.Lpush(values[1:]...)
+	m.serveBlocked(key)
 	return num, nil
 }
 
 
-func (m *Memdb)Lpop(key string) ([]byte,error) {
-
-	if m.dlList == nil{
-		return nil, nil
-	}
+//Pushx pushes values to the head or the tail of the list at values[0] only if
+//the key already exists
+func (m *Memdb) Pushx(left bool, values ...[]byte) (int, error) {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
-	if _,found := m.dlList[key];!found{
-		return nil,nil
+	key := string(values[0])
+	o, err := m.lookupWrite(key, ObjList)
+	if o == nil {
+		return 0, err
+	}
+	method := "rpush"
+	if left {
+		method = "lpush"
 	}
 	if !m.recovebool {
-		err := m.s.w.save(&Opt{Method: "lpop", Key: key})
+		err := m.save(&Opt{Method: method, Args: values})
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
 	}
-	return m.dlList[key].Lpop(),nil
+	if left {
+		return o.List.Lpush(values[1:]...), nil
+	}
+	return o.List.Rpush(values[1:]...), nil
+}
+
+func (m *Memdb)Lpop(key string) ([]byte,error) {
+	vals, err := m.Pop(key, true, 1)
+	if len(vals) == 0 {
+		return nil, err
+	}
+	return vals[0], nil
 }
 
 func (m *Memdb)Rpop(key string) ([]byte,error) {
-	if m.dlList == nil{
-		return nil, nil
+	vals, err := m.Pop(key, false, 1)
+	if len(vals) == 0 {
+		return nil, err
 	}
+	return vals[0], nil
+}
+
+//Pop removes up to count elements from the head or the tail of the list at
+//key, nil means the key does not exist
+func (m *Memdb) Pop(key string, left bool, count int) ([][]byte, error) {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
-	if _,found := m.dlList[key];!found{
-		return nil,nil
+	o, err := m.lookupWrite(key, ObjList)
+	if o == nil {
+		return nil, err
+	}
+	return m.pop(key, o, left, count)
+}
+
+//pop removes up to count elements from the list o stored at key and logs the
+//operation, the caller must hold the write lock
+func (m *Memdb) pop(key string, o *Object, left bool, count int) ([][]byte, error) {
+	vals := [][]byte{}
+	if count == 0 {
+		return vals, nil
+	}
+	method := "rpop"
+	if left {
+		method = "lpop"
 	}
 	if !m.recovebool {
-		err := m.s.w.save(&Opt{Method: "lpop", Key: key})
+		err := m.save(&Opt{Method: method, Key: key, Args: [][]byte{[]byte(strconv.Itoa(count))}})
 		if err != nil {
 			return nil, err
 		}
 	}
-	return m.dlList[key].Rpop(),nil
+	for i := 0; i < count && o.List.Size() > 0; i++ {
+		if left {
+			vals = append(vals, o.List.Lpop())
+		} else {
+			vals = append(vals, o.List.Rpop())
+		}
+	}
+	if o.List.Size() == 0 {
+		m.delKey(key)
+	}
+	return vals, nil
 }
 
-//set operation
-func (m *Memdb) Sadd (key string, values ...[]byte) (int ,error){
+//Lmpop pops up to count elements from the first non empty list among keys and
+//returns its name, an empty name means all the lists are empty
+func (m *Memdb) Lmpop(keys []string, left bool, count int) (string, [][]byte, error) {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
-	if _, exists := m.HSet[key]; !exists {
-		m.HSet[key] = structure.NewSset(key)
-	}
-
-	if !m.recovebool {
-		bytes := make([][]byte, 0)
-		for _, v := range values {
-			bytes = append(bytes, []byte(v))
-		}
-		err := m.s.w.save(&Opt{Method: "sadd", Key: key, Args: bytes})
+	for _, key := range keys {
+		o, err := m.lookupWrite(key, ObjList)
 		if err != nil {
-			return 0, err
+			return "", nil, err
+		}
+		if o != nil {
+			vals, err := m.pop(key, o, left, count)
+			return key, vals, err
 		}
 	}
-
-	count := 0
-	for _,value :=range values {
-		count =count + m.HSet[key].Add(string(value))
-	}
-	return count,nil
-}
-
-
-func (m *Memdb) Scard (key string)( int,error) {
-	m.rwmu.RLock()
-	defer m.rwmu.RUnlock()
-
-	if _, exists := m.HSet[key]; !exists {
-		return 0,nil
-	}
-	return m.HSet[key].Len(),nil
+	return "", nil, nil
 }
 
-func (m *Memdb)Spop(key string)( []byte,error)  {
+//Lmove atomically pops an element from src and pushes it to dst, srcLeft and
+//dstLeft choose the side of each list. It returns nil if src does not exist.
+func (m *Memdb) Lmove(src, dst string, srcLeft, dstLeft bool) ([]byte, error) {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
-
-	if _, exists := m.HSet[key]; !exists {
-		return nil,nil
+	v, err := m.lmove(src, dst, srcLeft, dstLeft)
+	if v != nil {
+		m.serveBlocked(dst)
 	}
+	return v, err
+}
 
-	if m.HSet[key].Len() == 0 {
-		return nil,nil
+//lmove does the work of Lmove, the caller must hold the write lock
+func (m *Memdb) lmove(src, dst string, srcLeft, dstLeft bool) ([]byte, error) {
+	o, err := m.lookupWrite(src, ObjList)
+	if o == nil {
+		return nil, err
+	}
+	d, err := m.lookupWrite(dst, ObjList)
+	if err != nil {
+		return nil, err
 	}
-	v := m.HSet[key].RandomKey()
 	if !m.recovebool {
-		m.s.w.save(&Opt{Method:"spop",Key:key,Args:[][]byte{[]byte(v)}})
+		//一条wal记录完成整个移动，回放时不会出现只执行了一半的情况
+		err := m.save(&Opt{Method: "lmove", Key: src, Args: [][]byte{[]byte(dst), listSide(srcLeft), listSide(dstLeft)}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var v []byte
+	if srcLeft {
+		v = o.List.Lpop()
+	} else {
+		v = o.List.Rpop()
+	}
+	if o.List.Size() == 0 {
+		m.delKey(src)
+		if src == dst {
+			d = nil
+		}
+	}
+	if d == nil {
+		d = newListObject()
+		m.setKey(dst, d)
+	}
+	if dstLeft {
+		d.List.Lpush(v)
+	} else {
+		d.List.Rpush(v)
 	}
-	m.HSet[key].Del(v)
-	return []byte(v),nil
+	return v, nil
 }
 
-func (m * Memdb)spop(key string,k []byte)  {
-	if _, exists := m.HSet[key]; !exists {
-		return
+func listSide(left bool) []byte {
+	if left {
+		return []byte("left")
 	}
-	m.HSet[key].Del(string(k))
+	return []byte("right")
 }
 
-
-func (m *Memdb) Smembers (key string)  ([][]byte,error) {
+func (m *Memdb) Llen(key string) (int, error) {
 	m.rwmu.RLock()
 	defer m.rwmu.RUnlock()
-	if _, exists := m.HSet[key]; !exists {
-		return nil,nil
+	o, err := m.lookupRead(key, ObjList)
+	if o == nil {
+		return 0, err
 	}
-
-	return *m.HSet[key].Members(),nil
+	return o.List.Size(), nil
 }
 
+var ErrIndexOutOfRange = errors.New("ERR index out of range")
 
-
-//hash set
-func (m *Memdb) Hget(key, subkey string) ([]byte, error) {
-	if m.Hvalues == nil {
-		return nil, nil
+func (m *Memdb) Lset(key string, index int, value []byte) error {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjList)
+	if err != nil {
+		return err
 	}
-	m.rwmu.RLock()
-	defer m.rwmu.RUnlock()
-	if v, exists := m.Hvalues[key]; exists {
-		if v, exists := v[subkey]; exists {
-			return v, nil
+	if o == nil {
+		return ErrNoSuchKey
+	}
+	if index < 0 {
+		index += o.List.Size()
+	}
+	if index < 0 || index >= o.List.Size() {
+		return ErrIndexOutOfRange
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "lset", Key: key, Args: [][]byte{[]byte(strconv.Itoa(index)), value}})
+		if err != nil {
+			return err
 		}
 	}
-	return nil, nil
+	o.List.Set(index, value)
+	return nil
 }
 
-func (m *Memdb) Hset(key, subkey string, value []byte) (int, error) {
-	ret := 0
+//Linsert inserts value before or after pivot, it returns the new length, -1
+//when pivot is not found and 0 when the key does not exist
+func (m *Memdb) Linsert(key string, after bool, pivot, value []byte) (int, error) {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
-	if _, exists := m.Hvalues[key]; !exists {
-		m.Hvalues[key] = make(HashValue)
-		ret = 1
+	o, err := m.lookupWrite(key, ObjList)
+	if o == nil {
+		return 0, err
+	}
+	where := []byte("before")
+	if after {
+		where = []byte("after")
 	}
-	if _, exists := m.Hvalues[key][subkey]; !exists {
-		ret = 1
+	if len(o.List.Positions(pivot, 1, 1, 0)) == 0 {
+		return -1, nil
 	}
 	if !m.recovebool {
-		err := m.s.w.save(&Opt{Method:"hset",Key:key,Args:[][]byte{[]byte(subkey),value}})
+		err := m.save(&Opt{Method: "linsert", Key: key, Args: [][]byte{where, pivot, value}})
 		if err != nil {
-			return 0,err
+			return 0, err
 		}
 	}
-	m.Hvalues[key][subkey] = value
-	return ret, nil
-}
-
-func (m *Memdb) Hgetall(key string) (HashValue, error) {
-	if  m.Hvalues == nil {
-		return nil, nil
-	}
-	m.rwmu.RLock()
-	defer m.rwmu.RUnlock()
-	return m.Hvalues[key], nil
-}
-
-func (m *Memdb) Get(key string) ([]byte, error) {
-	m.rwmu.RLock()
-	defer m.rwmu.RUnlock()
-	return m.Values[key], nil
+	o.List.InsertPivot(pivot, value, after)
+	return o.List.Size(), nil
 }
 
-func (m *Memdb) Set(key string, value []byte) error {
+func (m *Memdb) Lrem(key string, count int, value []byte) (int, error) {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjList)
+	if o == nil {
+		return 0, err
+	}
+	if len(o.List.Positions(value, 1, 1, 0)) == 0 {
+		return 0, nil
+	}
 	if !m.recovebool {
-		err := m.s.w.save(&Opt{Method: "set", Key: key, Args: [][]byte{value}})
+		err := m.save(&Opt{Method: "lrem", Key: key, Args: [][]byte{[]byte(strconv.Itoa(count)), value}})
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
-	m.Values[key] = value
-	return nil
+	removed := o.List.RemoveValue(value, count)
+	if o.List.Size() == 0 {
+		m.delKey(key)
+	}
+	return removed, nil
 }
 
-
-func (m *Memdb) Mset(values ...[]byte) error {
+func (m *Memdb) Ltrim(key string, start, stop int) error {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
-	if len(values) % 2 != 0 {
-		return errors.New("wrong number of arguments for MSET")
-	}
-
-	var bytes [][]byte
-	kvmap := make(map[string][]byte)
-	for i,v:= range values {
-		bytes = append(bytes,[]byte(v))
-		if i % 2 == 0 {
-			kvmap[string(v)] = values[i+1]
-		}
+	o, err := m.lookupWrite(key, ObjList)
+	if o == nil {
+		return err
 	}
 	if !m.recovebool {
-		err := m.s.w.save(&Opt{Method: "mset", Args: bytes})
+		err := m.save(&Opt{Method: "ltrim", Key: key, Args: [][]byte{[]byte(strconv.Itoa(start)), []byte(strconv.Itoa(stop))}})
 		if err != nil {
 			return err
 		}
 	}
-	for k,v:= range kvmap {
-		m.Values[k] = v
+	size := o.List.Size()
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	o.List.Trim(start, stop)
+	if o.List.Size() == 0 {
+		m.delKey(key)
 	}
 	return nil
 }
 
+//Lpos returns the indexes of the elements equal to value, see List.Positions
+func (m *Memdb) Lpos(key string, value []byte, rank, count, maxlen int) ([]int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjList)
+	if o == nil {
+		return nil, err
+	}
+	return o.List.Positions(value, rank, count, maxlen), nil
+}
 
-func (m *Memdb) Incr (key string) (int, error) {
+//set operation
+func (m *Memdb) Sadd (key string, values ...[]byte) (int ,error){
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
-	v,found := m.Values[key]
-	num  := 0
-	var err error
-	if found {
-		num ,err = strconv.Atoi(string(v))
-		if err != nil {
-			return 0,errors.New("value is not an integer or out of range")
-		}
+	o, err := m.lookupWrite(key, ObjSet)
+	if err != nil {
+		return 0, err
 	}
+
 	if !m.recovebool {
-		err := m.s.w.save(&Opt{Method: "incr", Key: key})
+		bytes := make([][]byte, 0)
+		for _, v := range values {
+			bytes = append(bytes, []byte(v))
+		}
+		err := m.save(&Opt{Method: "sadd", Key: key, Args: bytes})
 		if err != nil {
-			return 0,err
+			return 0, err
 		}
 	}
-	m.Values[key] = []byte(fmt.Sprintf("%d",num+1))
-	return num ,nil
+	if o == nil {
+		o = newSetObject(key, m.setMaxIntsetEntries)
+		m.setKey(key, o)
+	}
+
+	count := 0
+	for _,value :=range values {
+		count =count + o.Set.Add(string(value))
+	}
+	return count,nil
 }
 
-func (m *Memdb) Del(keys ...[]byte) (int, error) {
+
+func (m *Memdb) Scard (key string)( int,error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjSet)
+	if o == nil {
+		return 0, err
+	}
+	return o.Set.Len(),nil
+}
+
+//Spop removes and returns count random members, the wal records the removed
+//members so replay does not depend on the random choice
+func (m *Memdb) Spop(key string, count int) ([][]byte, error) {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
-	count := 0
+	o, err := m.lookupWrite(key, ObjSet)
+	if o == nil || count == 0 {
+		return nil, err
+	}
+	keys := o.Set.RandomKeys(count)
+	members := make([][]byte, len(keys))
+	for i, k := range keys {
+		members[i] = []byte(k)
+	}
 	if !m.recovebool {
-		err := m.s.w.save(&Opt{Method: "del", Args: keys})
+		err := m.save(&Opt{Method: "srem", Key: key, Args: members})
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
 	for _, k := range keys {
-		key := string(k)
-		if _, exists := m.Values[key]; exists {
-			delete(m.Values, key)
-			count++
-		}
-		if _, exists := m.Hvalues[key]; exists {
-			delete(m.Hvalues, key)
-			count++
-		}
-		if _, exists := m.HSet[key]; exists {
-			delete(m.HSet, key)
-			count++
-		}
+		o.Set.Del(k)
+	}
+	if o.Set.Len() == 0 {
+		m.delKey(key)
+	}
+	return members, nil
+}
 
-		if _, exists := m.HList[key]; exists {
-			delete(m.HList, key)
-			count++
-		}
-		if _, exists := m.skiplist[key]; exists {
-			delete(m.skiplist, key)
-			//count++
-		}
-		if _, exists := m.HSortSet[key]; exists {
-			delete(m.HSortSet, key)
-			count++
+//Srandmember returns count distinct random members, with a negative count
+//-count members that may repeat
+func (m *Memdb) Srandmember(key string, count int) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjSet)
+	if o == nil {
+		return nil, err
+	}
+	var members [][]byte
+	if count >= 0 {
+		for _, k := range o.Set.RandomKeys(count) {
+			members = append(members, []byte(k))
 		}
+		return members, nil
 	}
-	return count, nil
+	for i := 0; i < -count; i++ {
+		members = append(members, []byte(o.Set.RandomKey()))
+	}
+	return members, nil
 }
 
-//sort set
-func (m *Memdb) Zadd (key string,score float64,val string) (int ,error){
+func (m *Memdb) Srem(key string, members ...[]byte) (int, error) {
 	m.rwmu.Lock()
 	defer m.rwmu.Unlock()
-	if _, exists := m.HSortSet[key]; !exists {
-		m.HSortSet[key] = make(HashFloat)
-	}
-	if _, exists := m.skiplist[key]; !exists {
-		m.skiplist[key] = structure.NewSkipList()
+	o, err := m.lookupWrite(key, ObjSet)
+	if o == nil {
+		return 0, err
 	}
 	if !m.recovebool {
-		bytes := make([][]byte, 0)
-		bytes = append(bytes, []byte(val))
-		bytes = append(bytes, FloatToBytes(score))
-		err := m.s.w.save(&Opt{Method: "zadd", Key: key, Args: bytes})
+		err := m.save(&Opt{Method: "srem", Key: key, Args: members})
 		if err != nil {
 			return 0, err
 		}
 	}
 	count := 0
-	old ,found :=m.HSortSet[key][val]
-	if !found {
-		count = 1
-	}else {
-		//del the old key val
-		m.skiplist[key].Delete(old,val)
+	for _, member := range members {
+		count += o.Set.Remove(string(member))
 	}
-	m.HSortSet[key][val] = score
-	m.skiplist[key].Set(score,val)
-	return count,nil
+	if o.Set.Len() == 0 {
+		m.delKey(key)
+	}
+	return count, nil
 }
 
-func (m *Memdb) Zrange(key string, start, stop int,args ...[]byte) (*[][]byte, error) {
-	if _, exists := m.HSortSet[key]; !exists {
-		return nil,nil
-	}
-	if _, exists := m.skiplist[key]; !exists {
-		return nil,nil
+//Smismember reports for each member whether it belongs to the set at key
+func (m *Memdb) Smismember(key string, members ...[]byte) ([]int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjSet)
+	if err != nil {
+		return nil, err
 	}
-	withscores := false
-
-	if len (args) > 0  {
-		if strings.ToLower(string(args[0])) != "withscores"{
-			return nil,errors.New("ERR syntax error")
-		}else{
-			withscores = true
+	ret := make([]int, len(members))
+	if o != nil {
+		for i, member := range members {
+			ret[i] = o.Set.Exists(string(member))
 		}
 	}
-	m.rwmu.RLock()
-	defer m.rwmu.RUnlock()
-
+	return ret, nil
+}
 
-	if start < 0 {
-		if start =  m.skiplist[key].Len() + start; start < 0 {
-			start = 0
+//Smove moves member from the set at src to the set at dst
+func (m *Memdb) Smove(src, dst string, member []byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(src, ObjSet)
+	if err != nil {
+		return 0, err
+	}
+	d, err := m.lookupWrite(dst, ObjSet)
+	if err != nil {
+		return 0, err
+	}
+	if o == nil || o.Set.Exists(string(member)) == 0 {
+		return 0, nil
+	}
+	if src == dst {
+		return 1, nil
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "smove", Key: src, Args: [][]byte{[]byte(dst), member}})
+		if err != nil {
+			return 0, err
 		}
 	}
+	o.Set.Del(string(member))
+	if o.Set.Len() == 0 {
+		m.delKey(src)
+	}
+	if d == nil {
+		d = newSetObject(dst, m.setMaxIntsetEntries)
+		m.setKey(dst, d)
+	}
+	d.Set.Add(string(member))
+	return 1, nil
+}
 
-	if stop < 0 {
-		stop =  m.skiplist[key].Len() + stop
-		if stop <0 {
-			return nil,nil
+func (m * Memdb)spop(key string,k []byte)  {
+	o, _ := m.lookupWrite(key, ObjSet)
+	if o == nil {
+		return
+	}
+	o.Set.Del(string(k))
+	if o.Set.Len() == 0 {
+		m.delKey(key)
+	}
+}
+
+
+func (m *Memdb) Smembers (key string)  ([][]byte,error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjSet)
+	if o == nil {
+		return nil, err
+	}
+	return *o.Set.Members(),nil
+}
+
+
+
+//lookupSets returns the sets at keys, nil for the keys that do not exist
+func (m *Memdb) lookupSets(keys []string) ([]*structure.Set, error) {
+	sets := make([]*structure.Set, len(keys))
+	for i, key := range keys {
+		o, err := m.lookupRead(key, ObjSet)
+		if err != nil {
+			return nil, err
+		}
+		if o != nil {
+			sets[i] = o.Set
 		}
 	}
+	return sets, nil
+}
 
-	if _, exists := m.skiplist[key]; !exists {
-		return nil,nil
+//interSets returns the intersection of sets, stopping after limit members when
+//limit > 0. The smallest set is iterated and checked against the others.
+func interSets(sets []*structure.Set, limit int) [][]byte {
+	ret := [][]byte{}
+	for _, set := range sets {
+		if set == nil {
+			return ret
+		}
 	}
-	iter := m.skiplist[key].IndexRange(start,stop)
-	var ret [][]byte
-	for iter.Next() {
-		if withscores {
-			ret = append(ret, []byte(strconv.FormatFloat(iter.Key(), 'g', -1, 64)))
+	sorted := append([]*structure.Set(nil), sets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Len() < sorted[j].Len()
+	})
+	for _, member := range *sorted[0].Members() {
+		in := true
+		for _, set := range sorted[1:] {
+			if set.Exists(string(member)) == 0 {
+				in = false
+				break
+			}
+		}
+		if in {
+			ret = append(ret, member)
+			if limit > 0 && len(ret) == limit {
+				break
+			}
 		}
-		ret = append(ret,[]byte(iter.Value()))
 	}
-	iter.Close()
-	return &ret, nil
+	return ret
 }
 
-func (m *Memdb)ZrangeByScore(key string, start, stop []byte,args ...[]byte) (*[][]byte, error) {
-	if _, exists := m.HSortSet[key]; !exists {
-		return nil,nil
+func unionSets(sets []*structure.Set) [][]byte {
+	seen := make(map[string]struct{})
+	ret := [][]byte{}
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		for _, member := range *set.Members() {
+			if _, found := seen[string(member)]; !found {
+				seen[string(member)] = struct{}{}
+				ret = append(ret, member)
+			}
+		}
 	}
-	if _, exists := m.skiplist[key]; !exists {
-		return nil,nil
+	return ret
+}
+
+//diffSets returns the members of the first set that are in none of the others
+func diffSets(sets []*structure.Set) [][]byte {
+	ret := [][]byte{}
+	if sets[0] == nil {
+		return ret
+	}
+	for _, member := range *sets[0].Members() {
+		in := false
+		for _, set := range sets[1:] {
+			if set != nil && set.Exists(string(member)) == 1 {
+				in = true
+				break
+			}
+		}
+		if !in {
+			ret = append(ret, member)
+		}
 	}
-	min,err1 := strconv.ParseFloat(string(start),64)
-	max,err2 := strconv.ParseFloat(string(stop),64)
-	if err1 != nil && (string(start) != "-inf" && start[0] != '(' ) {
-		return nil,errors.New("ERR wrong number of arguments for zrangebyscore command")
+	return ret
+}
+
+//setAlgebra computes op, one of "inter", "union" or "diff", between the sets at keys
+func (m *Memdb) setAlgebra(op string, keys []string) ([][]byte, error) {
+	sets, err := m.lookupSets(keys)
+	if err != nil {
+		return nil, err
 	}
-	if err2!= nil && (string(stop) != "+inf" && stop[0] != '(') {
-		return nil,errors.New("ERR wrong number of arguments for zrangebyscore command")
+	switch op {
+	case "inter":
+		return interSets(sets, 0), nil
+	case "union":
+		return unionSets(sets), nil
 	}
+	return diffSets(sets), nil
+}
+
+func (m *Memdb) Sinter(keys ...string) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	return m.setAlgebra("inter", keys)
+}
+
+func (m *Memdb) Sunion(keys ...string) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	return m.setAlgebra("union", keys)
+}
+
+func (m *Memdb) Sdiff(keys ...string) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	return m.setAlgebra("diff", keys)
+}
 
-	moreTlanStart := false
-	lessTlanEnd := false
-	if start[0] == '(' {
+//Sintercard returns the size of the intersection, counting at most limit
+//members when limit > 0
+func (m *Memdb) Sintercard(keys []string, limit int) (int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	sets, err := m.lookupSets(keys)
+	if err != nil {
+		return 0, err
+	}
+	return len(interSets(sets, limit)), nil
+}
 
-		_,err:=fmt.Sscanf(string(start),"(%f",&min)
-		if err !=nil {
-			return nil,errors.New("ERR wrong number of arguments for zrangebyscore command")
+//SetStore stores the result of op between the sets at keys into dest and
+//returns its size. The wal records the resulting members, not the operation.
+func (m *Memdb) SetStore(op string, dest string, keys ...string) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	for _, key := range keys {
+		m.expireIfNeeded(key)
+	}
+	members, err := m.setAlgebra(op, keys)
+	if err != nil {
+		return 0, err
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "setstore", Key: dest, Args: members})
+		if err != nil {
+			return 0, err
 		}
-		moreTlanStart = true
 	}
+	m.setStore(dest, members)
+	return len(members), nil
+}
+
+//setStore replaces dest with a set holding members, an empty set deletes dest
+func (m *Memdb) setStore(dest string, members [][]byte) {
+	m.delKey(dest)
+	if len(members) == 0 {
+		return
+	}
+	o := newSetObject(dest, m.setMaxIntsetEntries)
+	for _, member := range members {
+		o.Set.Add(string(member))
+	}
+	m.setKey(dest, o)
+}
+
+//hash set
+func (m *Memdb) Hget(key, subkey string) ([]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjHash)
+	if o == nil {
+		return nil, err
+	}
+	return m.hashField(o, subkey), nil
+}
+
+//hashField returns the value of field, nil if it does not exist or is expired.
+//An empty value is returned as a non-nil slice so callers can tell it apart.
+func (m *Memdb) hashField(o *Object, field string) []byte {
+	v, exists := o.Hash[field]
+	if !exists || m.fieldExpired(o, field) {
+		return nil
+	}
+	if v == nil {
+		return []byte{}
+	}
+	return v
+}
 
-	if stop[0] == '(' {
-		_,err:=fmt.Sscanf(string(stop),"(%f",&max)
-		if err !=nil {
-			return nil,errors.New("ERR wrong number of arguments for zrangebyscore command")
+//Hset sets the field/value pairs in values, it returns the number of new fields
+func (m *Memdb) Hset(key string, values ...[]byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjHash)
+	if err != nil {
+		return 0, err
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method:"hset",Key:key,Args:values})
+		if err != nil {
+			return 0,err
 		}
-		lessTlanEnd = true
 	}
+	if o == nil {
+		o = newHashObject()
+		m.setKey(key, o)
+	}
+	ret := 0
+	for i := 0; i+1 < len(values); i += 2 {
+		if _, exists := o.Hash[string(values[i])]; !exists {
+			ret++
+		}
+		//覆盖字段的时候去掉它的过期时间
+		m.clearFieldTTL(key, o, string(values[i]))
+		o.Hash[string(values[i])] = values[i+1]
+	}
+	return ret, nil
+}
 
+//Hsetnx sets field only if it does not exist yet
+func (m *Memdb) Hsetnx(key, field string, value []byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjHash)
+	if err != nil {
+		return 0, err
+	}
+	if o != nil {
+		if _, exists := o.Hash[field]; exists {
+			return 0, nil
+		}
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method:"hset",Key:key,Args:[][]byte{[]byte(field),value}})
+		if err != nil {
+			return 0,err
+		}
+	}
+	if o == nil {
+		o = newHashObject()
+		m.setKey(key, o)
+	}
+	o.Hash[field] = value
+	return 1, nil
+}
 
-	withscores := false
-	if len (args) > 0  {
-		if strings.ToLower(string(args[0])) != "withscores"{
-			return nil,errors.New("ERR syntax error")
-		}else{
-			withscores = true
+//Hdel removes fields from the hash, the key is deleted with its last field
+func (m *Memdb) Hdel(key string, fields ...[]byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjHash)
+	if o == nil {
+		return 0, err
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method:"hdel",Key:key,Args:fields})
+		if err != nil {
+			return 0,err
+		}
+	}
+	count := 0
+	for _, field := range fields {
+		if _, exists := o.Hash[string(field)]; exists {
+			delete(o.Hash, string(field))
+			m.clearFieldTTL(key, o, string(field))
+			count++
 		}
 	}
+	if len(o.Hash) == 0 {
+		m.delKey(key)
+	}
+	return count, nil
+}
+
+func (m *Memdb) Hexists(key, field string) (int, error) {
 	m.rwmu.RLock()
 	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjHash)
+	if o == nil {
+		return 0, err
+	}
+	if m.hashField(o, field) != nil {
+		return 1, nil
+	}
+	return 0, nil
+}
 
-	if string(start) == "-inf" {
-		iter := m.skiplist[key].SeekToFirst()
-		min = iter.Key()
-		iter.Close()
+func (m *Memdb) Hlen(key string) (int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjHash)
+	if o == nil {
+		return 0, err
 	}
+	return m.hashLen(o), nil
+}
 
-	if string(stop) == "+inf" {
-		iter := m.skiplist[key].SeekToLast()
-		max = iter.Key()
-		iter.Close()
+func (m *Memdb) Hstrlen(key, field string) (int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjHash)
+	if o == nil {
+		return 0, err
 	}
-	if _, exists := m.skiplist[key]; !exists {
-		return nil,nil
+	return len(m.hashField(o, field)), nil
+}
+
+//Hmget returns the values of fields, nil for the fields that do not exist
+func (m *Memdb) Hmget(key string, fields ...[]byte) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjHash)
+	if err != nil {
+		return nil, err
 	}
-	iter := m.skiplist[key].Range(min,max)
-	var ret [][]byte
-	for iter.Next() {
-		k := iter.Key()
-		if moreTlanStart {
-			if k == min {
-				continue
+	ret := make([][]byte, len(fields))
+	if o != nil {
+		for i, field := range fields {
+			ret[i] = m.hashField(o, string(field))
+		}
+	}
+	return ret, nil
+}
+
+func (m *Memdb) Hkeys(key string) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjHash)
+	if o == nil {
+		return nil, err
+	}
+	ret := make([][]byte, 0, len(o.Hash))
+	for field := range o.Hash {
+		if !m.fieldExpired(o, field) {
+			ret = append(ret, []byte(field))
+		}
+	}
+	return ret, nil
+}
+
+func (m *Memdb) Hvals(key string) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjHash)
+	if o == nil {
+		return nil, err
+	}
+	ret := make([][]byte, 0, len(o.Hash))
+	for field, v := range o.Hash {
+		if !m.fieldExpired(o, field) {
+			ret = append(ret, v)
+		}
+	}
+	return ret, nil
+}
+
+//Hincrby adds incr to the integer stored in field, the wal records the result
+func (m *Memdb) Hincrby(key, field string, incr int64) (int64, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjHash)
+	if err != nil {
+		return 0, err
+	}
+	var num int64
+	if o != nil {
+		if v, exists := o.Hash[field]; exists {
+			var ok bool
+			if num, ok = string2ll(v); !ok {
+				return 0, ErrHashNotInteger
 			}
 		}
-		if lessTlanEnd {
-			if k == max{
-				break
+	}
+	if (incr < 0 && num < 0 && incr < math.MinInt64-num) ||
+		(incr > 0 && num > 0 && incr > math.MaxInt64-num) {
+		return 0, ErrOverflow
+	}
+	num += incr
+	v := []byte(strconv.FormatInt(num, 10))
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "hsetkeepttl", Key: key, Args: [][]byte{[]byte(field), v}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if o == nil {
+		o = newHashObject()
+		m.setKey(key, o)
+	}
+	o.Hash[field] = v
+	return num, nil
+}
+
+//Hincrbyfloat is IncrByFloat for a field of the hash
+func (m *Memdb) Hincrbyfloat(key, field string, incr *big.Float) ([]byte, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjHash)
+	if err != nil {
+		return nil, err
+	}
+	num := new(big.Float).SetPrec(longDoublePrec)
+	if o != nil {
+		if v, exists := o.Hash[field]; exists {
+			var ok bool
+			if num, ok = string2ld(v); !ok {
+				return nil, ErrHashNotFloat
 			}
 		}
-		if withscores {
-			ret = append(ret, []byte(strconv.FormatFloat(k, 'g', -1, 64)))
+	}
+	if num.IsInf() || incr.IsInf() {
+		return nil, ErrNaNOrInf
+	}
+	num.Add(num, incr)
+	if isLongDoubleOverflow(num) {
+		return nil, ErrNaNOrInf
+	}
+	v := []byte(ld2string(num))
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "hsetkeepttl", Key: key, Args: [][]byte{[]byte(field), v}})
+		if err != nil {
+			return nil, err
 		}
-		ret = append(ret,[]byte(iter.Value()))
 	}
-	iter.Close()
-	return &ret, nil
+	if o == nil {
+		o = newHashObject()
+		m.setKey(key, o)
+	}
+	o.Hash[field] = v
+	return v, nil
+}
+
+//Hrandfield returns count distinct random fields, with a negative count -count
+//fields that may repeat. With values each field is followed by its value.
+func (m *Memdb) Hrandfield(key string, count int, withValues bool) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjHash)
+	if o == nil {
+		return nil, err
+	}
+	fields := make([]string, 0, len(o.Hash))
+	for field := range o.Hash {
+		if !m.fieldExpired(o, field) {
+			fields = append(fields, field)
+		}
+	}
+	var picked []string
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if count >= 0 {
+		if count > len(fields) {
+			count = len(fields)
+		}
+		for i := 0; i < count; i++ {
+			j := i + rand.Intn(len(fields)-i)
+			fields[i], fields[j] = fields[j], fields[i]
+		}
+		picked = fields[:count]
+	} else {
+		for i := 0; i < -count; i++ {
+			picked = append(picked, fields[rand.Intn(len(fields))])
+		}
+	}
+	ret := make([][]byte, 0, len(picked)*2)
+	for _, field := range picked {
+		ret = append(ret, []byte(field))
+		if withValues {
+			ret = append(ret, m.hashField(o, field))
+		}
+	}
+	return ret, nil
+}
+
+func (m *Memdb) Hgetall(key string) (HashValue, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjHash)
+	if o == nil {
+		return nil, err
+	}
+	//返回一份拷贝，写入的时候不在锁外面读map
+	ret := make(HashValue, len(o.Hash))
+	for field, v := range o.Hash {
+		if !m.fieldExpired(o, field) {
+			ret[field] = v
+		}
+	}
+	return ret, nil
+}
+
+func (m *Memdb) Get(key string) ([]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjString)
+	if o == nil {
+		return nil, err
+	}
+	//SETRANGE和位操作会原地修改o.Str，锁外使用的返回值要拷贝一份
+	return o.strCopy(), nil
+}
+
+func (m *Memdb) Set(key string, value []byte) error {
+	_, _, err := m.SetGeneric(key, value, 0, 0)
+	return err
+}
+
+//SetGeneric implements SET with its NX/XX/KEEPTTL/GET flags, when is the absolute
+//deadline in unix milliseconds (0 means no deadline). It returns the old value and
+//whether the value was written.
+func (m *Memdb) SetGeneric(key string, value []byte, flags int, when int64) ([]byte, bool, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	m.expireIfNeeded(key)
+	var old []byte
+	o, exists := m.keys[key]
+	if exists && o.Type == ObjString {
+		old = o.Str
+	}
+	if flags&setGet != 0 && exists && o.Type != ObjString {
+		return nil, false, ErrWrongType
+	}
+	if (flags&setNX != 0 && exists) || (flags&setXX != 0 && !exists) {
+		return old, false, nil
+	}
+	if !m.recovebool {
+		//wal记录绝对过期时间，回放的时候不受重启时间影响
+		args := [][]byte{value}
+		if when > 0 {
+			args = append(args, []byte("pxat"), []byte(strconv.FormatInt(when, 10)))
+		} else if flags&setKeepTTL != 0 {
+			args = append(args, []byte("keepttl"))
+		}
+		err := m.save(&Opt{Method: "set", Key: key, Args: args})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	m.setKey(key, newStringObject(value))
+	if when > 0 {
+		m.Expires[key] = when
+	} else if flags&setKeepTTL == 0 {
+		delete(m.Expires, key)
+	}
+	return old, true, nil
+}
+
+
+func (m *Memdb) Mset(values ...[]byte) error {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if len(values) % 2 != 0 {
+		return errors.New("wrong number of arguments for MSET")
+	}
+
+	var bytes [][]byte
+	kvmap := make(map[string][]byte)
+	for i,v:= range values {
+		bytes = append(bytes,[]byte(v))
+		if i % 2 == 0 {
+			kvmap[string(v)] = values[i+1]
+		}
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "mset", Args: bytes})
+		if err != nil {
+			return err
+		}
+	}
+	for k,v:= range kvmap {
+		m.setKey(k, newStringObject(v))
+		delete(m.Expires, k)
+	}
+	return nil
+}
+
+
+func (m *Memdb) Mget(keys ...[]byte) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	ret := make([][]byte, len(keys))
+	for i, k := range keys {
+		//不是字符串的key返回nil
+		if o, _ := m.lookupRead(string(k), ObjString); o != nil {
+			ret[i] = o.strCopy()
+		}
+	}
+	return ret, nil
+}
+
+func (m *Memdb) Getset(key string, value []byte) ([]byte, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjString)
+	if err != nil {
+		return nil, err
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "getset", Key: key, Args: [][]byte{value}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.setKey(key, newStringObject(value))
+	delete(m.Expires, key)
+	if o == nil {
+		return nil, nil
+	}
+	return o.Str, nil
+}
+
+func (m *Memdb) Getdel(key string) ([]byte, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjString)
+	if o == nil {
+		return nil, err
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "getdel", Key: key})
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.delKey(key)
+	return o.Str, nil
+}
+
+//Getex returns the value of key and changes its deadline, with persist the
+//deadline is removed, otherwise a when > 0 sets it
+func (m *Memdb) Getex(key string, when int64, persist bool) ([]byte, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjString)
+	if o == nil {
+		return nil, err
+	}
+	var args [][]byte
+	if persist {
+		if _, found := m.Expires[key]; found {
+			args = [][]byte{[]byte("persist")}
+		}
+	} else if when > 0 {
+		args = [][]byte{[]byte("pxat"), []byte(strconv.FormatInt(when, 10))}
+	}
+	if args == nil {
+		return o.strCopy(), nil
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "getex", Key: key, Args: args})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if persist {
+		delete(m.Expires, key)
+	} else {
+		m.Expires[key] = when
+	}
+	return o.strCopy(), nil
+}
+
+func (m *Memdb) Setnx(key string, value []byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	m.expireIfNeeded(key)
+	if m.exists(key) {
+		return 0, nil
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "setnx", Key: key, Args: [][]byte{value}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	m.setKey(key, newStringObject(value))
+	return 1, nil
+}
+
+//Setex sets key with the absolute deadline when in unix milliseconds,
+//it implements both SETEX and PSETEX
+func (m *Memdb) Setex(key string, value []byte, when int64) error {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "setex", Key: key, Args: [][]byte{value, []byte(strconv.FormatInt(when, 10))}})
+		if err != nil {
+			return err
+		}
+	}
+	m.delKey(key)
+	m.setKey(key, newStringObject(value))
+	m.Expires[key] = when
+	return nil
+}
+
+//Msetnx sets all the given keys only if none of them exists
+func (m *Memdb) Msetnx(values ...[]byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if len(values) % 2 != 0 {
+		return 0, errors.New("ERR wrong number of arguments for MSETNX")
+	}
+	for i := 0; i < len(values); i += 2 {
+		key := string(values[i])
+		m.expireIfNeeded(key)
+		if m.exists(key) {
+			return 0, nil
+		}
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "msetnx", Args: values})
+		if err != nil {
+			return 0, err
+		}
+	}
+	for i := 0; i < len(values); i += 2 {
+		m.setKey(string(values[i]), newStringObject(values[i+1]))
+	}
+	return 1, nil
+}
+
+func (m *Memdb) Strlen(key string) (int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjString)
+	if o == nil {
+		return 0, err
+	}
+	return len(o.Str), nil
+}
+
+func (m *Memdb) Append(key string, value []byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjString)
+	if err != nil {
+		return 0, err
+	}
+	if o != nil && int64(len(o.Str))+int64(len(value)) > m.s.conf.protoMaxBulkLen {
+		return 0, ErrStringTooLong
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "append", Key: key, Args: [][]byte{value}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if o == nil {
+		o = newStringObject(nil)
+		m.setKey(key, o)
+	}
+	//o.Str可能和命令里的其他参数共用底层数组，先限制容量让append重新分配
+	o.Str = append(o.Str[:len(o.Str):len(o.Str)], value...)
+	return len(o.Str), nil
+}
+
+func (m *Memdb) Getrange(key string, start, end int64) ([]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjString)
+	if o == nil {
+		return nil, err
+	}
+	strlen := int64(len(o.Str))
+	if start < 0 && end < 0 && start > end {
+		return nil, nil
+	}
+	if start < 0 {
+		start += strlen
+	}
+	if end < 0 {
+		end += strlen
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= strlen {
+		end = strlen - 1
+	}
+	if strlen == 0 || start > end {
+		return nil, nil
+	}
+	return append([]byte(nil), o.Str[start:end+1]...), nil
+}
+
+//Setrange overwrites the string at key from offset with value, padding with
+//zero bytes when it grows. Only the patch goes to the wal.
+func (m *Memdb) Setrange(key string, offset int64, value []byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	if offset < 0 {
+		return 0, ErrOffsetOutOfRange
+	}
+	o, err := m.lookupWrite(key, ObjString)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) == 0 {
+		if o == nil {
+			return 0, nil
+		}
+		return len(o.Str), nil
+	}
+	if offset+int64(len(value)) > m.s.conf.protoMaxBulkLen {
+		return 0, ErrStringTooLong
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "setrange", Key: key, Args: [][]byte{[]byte(strconv.FormatInt(offset, 10)), value}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if o == nil {
+		o = newStringObject(nil)
+		m.setKey(key, o)
+	}
+	o.grow(int(offset) + len(value))
+	copy(o.Str[offset:], value)
+	return len(o.Str), nil
+}
+
+//lcsMatch is a range matched by LCS IDX, as inclusive offsets in both strings
+type lcsMatch struct {
+	A, B [2]int
+	Len  int
+}
+
+//Lcs computes the longest common subsequence of the strings at key1 and key2,
+//missing keys count as empty strings. Matches shorter than minMatchLen are
+//left out, they are only collected when idx is set.
+func (m *Memdb) Lcs(key1, key2 string, idx bool, minMatchLen int64) ([]byte, []lcsMatch, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	var a, b []byte
+	for i, key := range []string{key1, key2} {
+		o, err := m.lookupRead(key, ObjString)
+		if err == ErrWrongType {
+			return nil, nil, errors.New("ERR The specified keys must contain string values")
+		}
+		if o != nil {
+			if i == 0 {
+				a = o.Str
+			} else {
+				b = o.Str
+			}
+		}
+	}
+	alen, blen := len(a), len(b)
+	if int64(alen+1)*int64(blen+1)*4 > m.s.conf.protoMaxBulkLen {
+		return nil, nil, errors.New("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+	}
+	//dp[i*(blen+1)+j]是a[:i]和b[:j]的LCS长度
+	dp := make([]uint32, (alen+1)*(blen+1))
+	lcs := func(i, j int) uint32 {
+		return dp[i*(blen+1)+j]
+	}
+	for i := 1; i <= alen; i++ {
+		for j := 1; j <= blen; j++ {
+			if a[i-1] == b[j-1] {
+				dp[i*(blen+1)+j] = lcs(i-1, j-1) + 1
+			} else if l1, l2 := lcs(i-1, j), lcs(i, j-1); l1 > l2 {
+				dp[i*(blen+1)+j] = l1
+			} else {
+				dp[i*(blen+1)+j] = l2
+			}
+		}
+	}
+	//从末尾往回走，相邻的字符合并成一个range，和redis的顺序一致
+	n := lcs(alen, blen)
+	result := make([]byte, n)
+	var matches []lcsMatch
+	astart, aend, bstart, bend := alen, 0, 0, 0
+	for i, j := alen, blen; i > 0 && j > 0; {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[n-1] = a[i-1]
+			if astart == alen {
+				astart, aend = i-1, i-1
+				bstart, bend = j-1, j-1
+			} else if astart == i && bstart == j {
+				astart--
+				bstart--
+			} else {
+				emit = true
+			}
+			if astart == 0 || bstart == 0 {
+				emit = true
+			}
+			n--
+			i--
+			j--
+		} else {
+			if lcs(i-1, j) > lcs(i, j-1) {
+				i--
+			} else {
+				j--
+			}
+			if astart != alen {
+				emit = true
+			}
+		}
+		if emit {
+			l := aend - astart + 1
+			if idx && int64(l) >= minMatchLen {
+				matches = append(matches, lcsMatch{A: [2]int{astart, aend}, B: [2]int{bstart, bend}, Len: l})
+			}
+			astart = alen
+		}
+	}
+	return result, matches, nil
+}
+
+func (m *Memdb) Incr (key string) (int64, error) {
+	return m.IncrBy(key, 1)
+}
+
+//IncrBy adds incr to the integer stored at key and returns the new value, the wal
+//records the resulting value so replay does not depend on the old one
+func (m *Memdb) IncrBy(key string, incr int64) (int64, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjString)
+	if err != nil {
+		return 0, err
+	}
+	var num int64
+	if o != nil {
+		var ok bool
+		if num, ok = string2ll(o.Str); !ok {
+			return 0, ErrNotInteger
+		}
+	}
+	if (incr < 0 && num < 0 && incr < math.MinInt64-num) ||
+		(incr > 0 && num > 0 && incr > math.MaxInt64-num) {
+		return 0, ErrOverflow
+	}
+	num += incr
+	v := []byte(strconv.FormatInt(num, 10))
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "set", Key: key, Args: [][]byte{v, []byte("keepttl")}})
+		if err != nil {
+			return 0,err
+		}
+	}
+	m.setKey(key, newStringObject(v))
+	return num ,nil
+}
+
+//IncrByFloat adds incr to the number stored at key with long double precision
+//like redis, the result is formatted the same way redis does
+func (m *Memdb) IncrByFloat(key string, incr *big.Float) ([]byte, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjString)
+	if err != nil {
+		return nil, err
+	}
+	num := new(big.Float).SetPrec(longDoublePrec)
+	if o != nil {
+		var ok bool
+		if num, ok = string2ld(o.Str); !ok {
+			return nil, ErrNotFloat
+		}
+	}
+	if num.IsInf() || incr.IsInf() {
+		return nil, ErrNaNOrInf
+	}
+	num.Add(num, incr)
+	if isLongDoubleOverflow(num) {
+		return nil, ErrNaNOrInf
+	}
+	v := []byte(ld2string(num))
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "set", Key: key, Args: [][]byte{v, []byte("keepttl")}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.setKey(key, newStringObject(v))
+	return v, nil
+}
+
+func (m *Memdb) Del(keys ...[]byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	count := 0
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "del", Args: keys})
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, k := range keys {
+		key := string(k)
+		if m.expireIfNeeded(key) {
+			continue
+		}
+		if _, exists := m.keys[key]; exists {
+			m.delKey(key)
+			count++
+		}
+	}
+	return count, nil
+}
+
+//flags of Zadd
+const (
+	zaddNX = 1 << iota
+	zaddXX
+	zaddGT
+	zaddLT
+	zaddCH
+	zaddINCR
+)
+
+var ErrScoreNaN = errors.New("ERR resulting score is not a number (NaN)")
+
+//sort set
+
+//Zadd adds members with their scores following the flags of ZADD, it returns
+//the number of added members, or added and updated ones with zaddCH. With
+//zaddINCR the score of the single member is incremented and the new score is
+//returned, nil if the flags prevented the update.
+//The wal records the final score of every changed member in one entry.
+func (m *Memdb) Zadd(key string, flags int, scores []float64, members []string) (int, *float64, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjZset)
+	if err != nil {
+		return 0, nil, err
+	}
+	//先算出每个成员最后的分数，写wal之后再修改
+	pending := make(map[string]float64)
+	var order []string
+	var incrScore *float64
+	added, changed := 0, 0
+	for i, member := range members {
+		score := scores[i]
+		cur, exists := pending[member]
+		if !exists && o != nil {
+			cur, exists = o.Zset.Dict[member]
+		}
+		if (exists && flags&zaddNX != 0) || (!exists && flags&zaddXX != 0) {
+			continue
+		}
+		if exists {
+			if flags&zaddINCR != 0 {
+				score += cur
+				if math.IsNaN(score) {
+					return 0, nil, ErrScoreNaN
+				}
+			}
+			if (flags&zaddGT != 0 && score <= cur) || (flags&zaddLT != 0 && score >= cur) {
+				continue
+			}
+			if score != cur {
+				changed++
+			}
+		} else {
+			added++
+		}
+		if _, found := pending[member]; !found {
+			order = append(order, member)
+		}
+		pending[member] = score
+		if flags&zaddINCR != 0 {
+			incrScore = &score
+		}
+	}
+	if len(order) == 0 {
+		return 0, incrScore, nil
+	}
+	if !m.recovebool {
+		args := make([][]byte, 0, len(order)*2)
+		for _, member := range order {
+			args = append(args, []byte(member), FloatToBytes(pending[member]))
+		}
+		err := m.save(&Opt{Method: "zadd", Key: key, Args: args})
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if o == nil {
+		o = newZsetObject()
+		m.setKey(key, o)
+	}
+	for _, member := range order {
+		o.Zset.Add(member, pending[member])
+	}
+	if flags&zaddCH != 0 {
+		return added + changed, incrScore, nil
+	}
+	return added, incrScore, nil
+}
+
+//zrangeSpec is a score interval of ZCOUNT and ZRANGEBYSCORE
+type zrangeSpec struct {
+	min, max     float64
+	minex, maxex bool //bounds are exclusive
+}
+
+var ErrMinMaxNotFloat = errors.New("ERR min or max is not a float")
+
+//parseZrangeSpec parses min and max, a '(' prefix makes a bound exclusive
+func parseZrangeSpec(min, max []byte) (*zrangeSpec, error) {
+	r := &zrangeSpec{}
+	var ok bool
+	if len(min) > 0 && min[0] == '(' {
+		min, r.minex = min[1:], true
+	}
+	if len(max) > 0 && max[0] == '(' {
+		max, r.maxex = max[1:], true
+	}
+	if r.min, ok = string2d(min); !ok {
+		return nil, ErrMinMaxNotFloat
+	}
+	if r.max, ok = string2d(max); !ok {
+		return nil, ErrMinMaxNotFloat
+	}
+	return r, nil
+}
+
+func (r *zrangeSpec) gteMin(v float64) bool {
+	if r.minex {
+		return v > r.min
+	}
+	return v >= r.min
+}
+
+func (r *zrangeSpec) lteMax(v float64) bool {
+	if r.maxex {
+		return v < r.max
+	}
+	return v <= r.max
+}
+
+//Zrem removes members, the key is deleted with its last member
+func (m *Memdb) Zrem(key string, members ...[]byte) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjZset)
+	if o == nil {
+		return 0, err
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "zrem", Key: key, Args: members})
+		if err != nil {
+			return 0, err
+		}
+	}
+	count := 0
+	for _, member := range members {
+		if o.Zset.Remove(string(member)) {
+			count++
+		}
+	}
+	if o.Zset.Len() == 0 {
+		m.delKey(key)
+	}
+	return count, nil
+}
+
+//Zmscore returns the scores of members, nil for the members that do not exist
+func (m *Memdb) Zmscore(key string, members ...[]byte) ([]*float64, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjZset)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*float64, len(members))
+	if o != nil {
+		for i, member := range members {
+			if score, found := o.Zset.Dict[string(member)]; found {
+				ret[i] = &score
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (m *Memdb) Zcard(key string) (int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjZset)
+	if o == nil {
+		return 0, err
+	}
+	return o.Zset.Len(), nil
+}
+
+//Zrank returns the 0-based rank of member ordered by score, from the highest
+//score with rev, and its score. The rank is -1 if member does not exist.
+func (m *Memdb) Zrank(key, member string, rev bool) (int, float64, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjZset)
+	if o == nil {
+		return -1, 0, err
+	}
+	score, found := o.Zset.Dict[member]
+	if !found {
+		return -1, 0, nil
+	}
+	rank := o.Zset.skiplist.Rank(score, member) - 1
+	if rev {
+		rank = o.Zset.Len() - 1 - rank
+	}
+	return rank, score, nil
+}
+
+//Zcount returns the number of members with a score in r
+func (m *Memdb) Zcount(key string, r *zrangeSpec) (int, error) {
+	return m.zrangeLen(key, &zrangeQuery{by: zrangeByScore, score: r})
+}
+
+//Zlexcount returns the number of members in the lexicographic range r
+func (m *Memdb) Zlexcount(key string, r *zlexSpec) (int, error) {
+	return m.zrangeLen(key, &zrangeQuery{by: zrangeByLex, lex: r})
+}
+
+//zrangeLen counts the members in the score or lex range q in O(log n)
+func (m *Memdb) zrangeLen(key string, q *zrangeQuery) (int, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjZset)
+	if o == nil {
+		return 0, err
+	}
+	first, last := q.bounds(o.Zset.skiplist)
+	if last < first {
+		return 0, nil
+	}
+	return last - first + 1, nil
+}
+
+//Zremrangebylex removes the members in the lexicographic range r, the key
+//is deleted with its last member
+func (m *Memdb) Zremrangebylex(key string, r *zlexSpec) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(key, ObjZset)
+	if o == nil {
+		return 0, err
+	}
+	members, _ := zrangeMembers(o, &zrangeQuery{by: zrangeByLex, lex: r, limit: -1})
+	if len(members) == 0 {
+		return 0, nil
+	}
+	if !m.recovebool {
+		args := make([][]byte, len(members))
+		for i, member := range members {
+			args[i] = []byte(member)
+		}
+		err := m.save(&Opt{Method: "zrem", Key: key, Args: args})
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, member := range members {
+		o.Zset.Remove(member)
+	}
+	if o.Zset.Len() == 0 {
+		m.delKey(key)
+	}
+	return len(members), nil
+}
+
+//zlexBound is a bound of a lexicographic range, inf is -1 for '-' and 1 for '+'
+type zlexBound struct {
+	s   string
+	ex  bool
+	inf int
+}
+
+//zlexSpec is a member interval of ZRANGEBYLEX and ZLEXCOUNT
+type zlexSpec struct {
+	min, max zlexBound
+}
+
+var ErrLexRange = errors.New("ERR min or max not valid string range item")
+
+func parseZlexBound(b []byte) (zlexBound, error) {
+	switch {
+	case len(b) == 1 && b[0] == '+':
+		return zlexBound{inf: 1}, nil
+	case len(b) == 1 && b[0] == '-':
+		return zlexBound{inf: -1}, nil
+	case len(b) > 0 && b[0] == '(':
+		return zlexBound{s: string(b[1:]), ex: true}, nil
+	case len(b) > 0 && b[0] == '[':
+		return zlexBound{s: string(b[1:])}, nil
+	}
+	return zlexBound{}, ErrLexRange
+}
+
+//parseZlexSpec parses min and max, '[' and '(' make a bound inclusive or
+//exclusive, '-' and '+' are the smallest and largest strings
+func parseZlexSpec(min, max []byte) (*zlexSpec, error) {
+	var r zlexSpec
+	var err error
+	if r.min, err = parseZlexBound(min); err != nil {
+		return nil, err
+	}
+	if r.max, err = parseZlexBound(max); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *zlexSpec) gteMin(v string) bool {
+	if r.min.inf != 0 {
+		return r.min.inf < 0
+	}
+	if r.min.ex {
+		return v > r.min.s
+	}
+	return v >= r.min.s
+}
+
+func (r *zlexSpec) lteMax(v string) bool {
+	if r.max.inf != 0 {
+		return r.max.inf > 0
+	}
+	if r.max.ex {
+		return v < r.max.s
+	}
+	return v <= r.max.s
+}
+
+//kinds of zrangeQuery
+const (
+	zrangeByRank = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+//zrangeQuery describes a range of ZRANGE and its variants
+type zrangeQuery struct {
+	by          int
+	start, stop int //by rank, negative counts from the end
+	score       *zrangeSpec
+	lex         *zlexSpec
+	rev         bool
+	offset      int //LIMIT, ignored by rank
+	limit       int //negative means no limit
+}
+
+//bounds returns the ranks of the first and last members in the score or lex
+//range, last < first when it is empty
+func (q *zrangeQuery) bounds(zsl *structure.SkipList) (int, int) {
+	if q.by == zrangeByScore {
+		first := zsl.CountWhile(func(k float64, _ string) bool { return !q.score.gteMin(k) })
+		last := zsl.CountWhile(func(k float64, _ string) bool { return q.score.lteMax(k) }) - 1
+		return first, last
+	}
+	first := zsl.CountWhile(func(_ float64, v string) bool { return !q.lex.gteMin(v) })
+	last := zsl.CountWhile(func(_ float64, v string) bool { return q.lex.lteMax(v) }) - 1
+	return first, last
+}
+
+//ranks returns the rank where the range starts and its length, the range
+//goes towards lower ranks with rev
+func (q *zrangeQuery) ranks(zsl *structure.SkipList) (int, int) {
+	length := zsl.Len()
+	if q.by == zrangeByRank {
+		start, stop := q.start, q.stop
+		if start < 0 {
+			start += length
+		}
+		if stop < 0 {
+			stop += length
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= length {
+			stop = length - 1
+		}
+		if start > stop {
+			return 0, 0
+		}
+		if q.rev {
+			return length - 1 - start, stop - start + 1
+		}
+		return start, stop - start + 1
+	}
+	first, last := q.bounds(zsl)
+	if q.offset < 0 {
+		return 0, 0
+	}
+	n := last - first + 1 - q.offset
+	if n <= 0 {
+		return 0, 0
+	}
+	if q.limit >= 0 && q.limit < n {
+		n = q.limit
+	}
+	if q.rev {
+		return last - q.offset, n
+	}
+	return first + q.offset, n
+}
+
+//zrangeMembers returns the members and scores in the range q of the sorted set o
+func zrangeMembers(o *Object, q *zrangeQuery) ([]string, []float64) {
+	zsl := o.Zset.skiplist
+	start, n := q.ranks(zsl)
+	if n == 0 {
+		return nil, nil
+	}
+	var iter structure.Iterator
+	if q.rev {
+		iter = zsl.RevIndexRange(start, start-n+1)
+	} else {
+		iter = zsl.IndexRange(start, start+n-1)
+	}
+	members := make([]string, 0, n)
+	scores := make([]float64, 0, n)
+	for iter.Next() {
+		members = append(members, iter.Value())
+		scores = append(scores, iter.Key())
+	}
+	iter.Close()
+	return members, scores
+}
+
+//Zrange returns the members in the range q, each followed by its score with withScores
+func (m *Memdb) Zrange(key string, q *zrangeQuery, withScores bool) ([][]byte, error) {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+	o, err := m.lookupRead(key, ObjZset)
+	if o == nil {
+		return nil, err
+	}
+	members, scores := zrangeMembers(o, q)
+	var ret [][]byte
+	for i, member := range members {
+		ret = append(ret, []byte(member))
+		if withScores {
+			ret = append(ret, []byte(d2string(scores[i])))
+		}
+	}
+	return ret, nil
+}
+
+//Zrangestore stores the range q of src at dest, the wal records the result
+func (m *Memdb) Zrangestore(dest, src string, q *zrangeQuery) (int, error) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+	o, err := m.lookupWrite(src, ObjZset)
+	if err != nil {
+		return 0, err
+	}
+	var args [][]byte
+	if o != nil {
+		members, scores := zrangeMembers(o, q)
+		for i, member := range members {
+			args = append(args, []byte(member), FloatToBytes(scores[i]))
+		}
+	}
+	if !m.recovebool {
+		err := m.save(&Opt{Method: "zsetstore", Key: dest, Args: args})
+		if err != nil {
+			return 0, err
+		}
+	}
+	m.zsetStore(dest, args)
+	return len(args) / 2, nil
+}
+
+//zsetStore replaces dest with a sorted set holding the member/score pairs of
+//args, an empty set deletes dest
+func (m *Memdb) zsetStore(dest string, args [][]byte) {
+	m.delKey(dest)
+	if len(args) == 0 {
+		return
+	}
+	o := newZsetObject()
+	for i := 0; i+1 < len(args); i += 2 {
+		o.Zset.Add(string(args[i]), BytesToFloat(args[i+1]))
+	}
+	m.setKey(dest, o)
 }
 
 
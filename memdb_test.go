@@ -0,0 +1,155 @@
+package newredis
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/vmihailenco/msgpack"
+	"github.com/widaT/yagowal/structure"
+)
+
+//legacySnapshotData returns a snapshot in the layout written before the
+//keyspace was unified
+func legacySnapshotData(t *testing.T) []byte {
+	t.Helper()
+	legacy := legacySnapshot{
+		Values: HashValue{"str": []byte("v"), "dup": []byte("string wins"), "tmp": []byte("t")},
+		Hvalues: HashHash{
+			"hash":  {"f1": []byte("1"), "f2": []byte("2")},
+			"dup":   {"f": []byte("v")},
+			"empty": {},
+		},
+		HList: HashList{"list": {[]byte("a"), []byte("b"), []byte("c")}},
+		HSortSet: HashHashInt{
+			"zset": {"a": 1, "b": 2.5},
+		},
+		Expires: map[string]int64{"tmp": 99999999999999, "list": 88888888888888, "gone": 1},
+	}
+	legacy.HSet = map[string]struct {
+		Mset map[string]struct{}
+	}{
+		"ints":  {Mset: map[string]struct{}{"3": {}, "1": {}, "2": {}}},
+		"words": {Mset: map[string]struct{}{"x": {}, "1": {}}},
+	}
+	b, err := msgpack.Marshal(&legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLegacySnapshotMigration(t *testing.T) {
+	s := newTestServer()
+	if err := s.recoverFromSnapshot(legacySnapshotData(t)); err != nil {
+		t.Fatal(err)
+	}
+	runCases(t, s, []cmdCase{
+		{"dbsize", ":8"},
+		{"get str", "$v"},
+		{"type dup", "+string"},
+		{"get dup", "$string wins"},
+		{"hmget hash f1 f2", "*2 $1 $2"},
+		{"exists empty", ":0"},
+		{"lrange list 0 -1", "*3 $a $b $c"},
+		{"zrange zset 0 -1 withscores", "*4 $a $1 $b $2.5"},
+		{"smembers ints", "*3 $1 $2 $3"},
+		{"object encoding ints", "$intset"},
+		{"object encoding words", "$hashtable"},
+		{"sismember words x", ":1"},
+		{"scard words", ":2"},
+		{"ttl str", ":-1"},
+		{"select 1", "+OK"},
+		{"dbsize", ":0"},
+	})
+	want := map[string]int64{"tmp": 99999999999999, "list": 88888888888888}
+	if !reflect.DeepEqual(s.dbs[0].Expires, want) {
+		t.Errorf("expires %v, want %v", s.dbs[0].Expires, want)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	s := newTestServer()
+	runCases(t, s, []cmdCase{
+		{"set str v px 100000", "+OK"},
+		{"rpush list a b c", ":3"},
+		{"sadd ints 3 1 2", ":3"},
+		{"sadd words x 1", ":2"},
+		{"zadd zset 1 a 2.5 b", ":2"},
+		{"hset hash f1 1 f2 2", ":2"},
+		{"hpexpire hash 100000 fields 1 f1", "*1 :1"},
+		{"select 3", "+OK"},
+		{"set other v", "+OK"},
+	})
+	b, err := s.getSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestServer()
+	if err := r.recoverFromSnapshot(b); err != nil {
+		t.Fatal(err)
+	}
+	assertSameKeyspace(t, s, r)
+	runCases(t, r, []cmdCase{
+		{"object encoding ints", "$intset"},
+		{"object encoding words", "$hashtable"},
+		{"hpexpiretime hash fields 2 f1 f2", "*2 :" + strconv.FormatInt(s.dbs[0].keys["hash"].FieldExpires["f1"], 10) + " :-1"},
+	})
+
+	//a snapshot of a single database written before SELECT existed
+	b, err = msgpack.Marshal(s.dbs[0].snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = newTestServer()
+	if err := r.recoverFromSnapshot(b); err != nil {
+		t.Fatal(err)
+	}
+	for i := range s.dbs {
+		want := 0
+		if i == 0 {
+			want = len(s.dbs[0].keys)
+		}
+		if len(r.dbs[i].keys) != want {
+			t.Errorf("db %d has %d keys, want %d", i, len(r.dbs[i].keys), want)
+		}
+	}
+	runCases(t, r, []cmdCase{
+		{"lrange list 0 -1", "*3 $a $b $c"},
+	})
+	if r.dbs[0].Expires["str"] != s.dbs[0].Expires["str"] {
+		t.Errorf("deadline %d, want %d", r.dbs[0].Expires["str"], s.dbs[0].Expires["str"])
+	}
+}
+
+//TestReplayAfterMigration checks that the wal written after loading a legacy
+//snapshot replays on top of the same snapshot to the same keyspace
+func TestReplayAfterMigration(t *testing.T) {
+	data := legacySnapshotData(t)
+	s := newTestServer()
+	if err := s.recoverFromSnapshot(data); err != nil {
+		t.Fatal(err)
+	}
+	runCases(t, s, []cmdCase{
+		{"append str w", ":2"},
+		{"hset hash f3 3", ":1"},
+		{"hdel hash f1", ":1"},
+		{"lpop list", "$a"},
+		{"rpush list d", ":3"},
+		{"sadd ints 4 x", ":2"},
+		{"object encoding ints", "$hashtable"},
+		{"srem words x", ":1"},
+		{"zadd zset incr 1 a", "$2"},
+		{"zrem zset b", ":1"},
+		{"persist tmp", ":1"},
+		{"expire str 1000", ":1"},
+		{"del dup", ":1"},
+		{"rename list list2", "+OK"},
+	})
+	r := newReplayServer()
+	if err := r.recoverFromSnapshot(data); err != nil {
+		t.Fatal(err)
+	}
+	r.w.applyEntries(append([]structure.Entry(nil), ents...))
+	assertSameKeyspace(t, s, r)
+}
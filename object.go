@@ -0,0 +1,259 @@
+package newredis
+
+import (
+	"github.com/widaT/newredis/structure"
+)
+
+type ObjectType byte
+
+const (
+	ObjString ObjectType = iota
+	ObjList
+	ObjSet
+	ObjZset
+	ObjHash
+)
+
+var objectTypeNames = map[ObjectType]string{
+	ObjString: "string",
+	ObjList:   "list",
+	ObjSet:    "set",
+	ObjZset:   "zset",
+	ObjHash:   "hash",
+}
+
+func (t ObjectType) String() string {
+	return objectTypeNames[t]
+}
+
+//Object is the value stored under a key of the keyspace, only the field
+//matching Type is used
+type Object struct {
+	Type ObjectType
+	Str  []byte
+	List *structure.List
+	Set  *structure.Set
+	Zset *SortSet
+	Hash HashValue
+	FieldExpires map[string]int64 //hash字段的过期时间，unix毫秒，没有时为nil
+}
+
+func newStringObject(v []byte) *Object {
+	return &Object{Type: ObjString, Str: v}
+}
+
+func newListObject() *Object {
+	return &Object{Type: ObjList, List: structure.NewList()}
+}
+
+func newSetObject(key string, maxIntset int) *Object {
+	return &Object{Type: ObjSet, Set: structure.NewSset(key, maxIntset)}
+}
+
+func newZsetObject() *Object {
+	return &Object{Type: ObjZset, Zset: NewSortSet()}
+}
+
+func newHashObject() *Object {
+	return &Object{Type: ObjHash, Hash: make(HashValue)}
+}
+
+//strCopy returns a copy of the string value, for callers that use it after
+//releasing the lock. The copy of an empty value isn't nil, nil means no key
+func (o *Object) strCopy() []byte {
+	b := make([]byte, len(o.Str))
+	copy(b, o.Str)
+	return b
+}
+
+//grow pads the string value with zero bytes up to size
+func (o *Object) grow(size int) {
+	if size <= len(o.Str) {
+		return
+	}
+	//o.Str可能和命令里的其他参数共用底层数组，重新分配而不是直接append
+	buf := make([]byte, size)
+	copy(buf, o.Str)
+	o.Str = buf
+}
+
+//dup returns a deep copy of o, key is the name of the new key
+func (o *Object) dup(key string) *Object {
+	switch o.Type {
+	case ObjString:
+		return newStringObject(append([]byte(nil), o.Str...))
+	case ObjList:
+		n := newListObject()
+		n.List.Add(o.List.Values()...)
+		return n
+	case ObjSet:
+		return &Object{Type: ObjSet, Set: o.Set.Dup(key)}
+	case ObjZset:
+		n := newZsetObject()
+		for member, score := range o.Zset.Dict {
+			n.Zset.Add(member, score)
+		}
+		return n
+	case ObjHash:
+		n := newHashObject()
+		for k, v := range o.Hash {
+			n.Hash[k] = append([]byte(nil), v...)
+		}
+		if o.FieldExpires != nil {
+			n.FieldExpires = make(map[string]int64, len(o.FieldExpires))
+			for k, when := range o.FieldExpires {
+				n.FieldExpires[k] = when
+			}
+		}
+		return n
+	}
+	return nil
+}
+
+//encoding returns the name of the internal representation of o, as
+//reported by OBJECT ENCODING
+func (o *Object) encoding() string {
+	switch o.Type {
+	case ObjList:
+		return "linkedlist"
+	case ObjSet:
+		return o.Set.Encoding()
+	case ObjZset:
+		return "skiplist"
+	case ObjHash:
+		return "hashtable"
+	}
+	return "raw"
+}
+
+//free drops the references held by o so the garbage collector can reclaim them
+func (o *Object) free() {
+	switch o.Type {
+	case ObjList:
+		o.List.Clear()
+	case ObjSet:
+		o.Set = nil
+	case ObjZset:
+		o.Zset = nil
+	case ObjHash:
+		o.Hash = nil
+		o.FieldExpires = nil
+	}
+	o.Str = nil
+}
+
+//SortSet keeps the member->score map and the skiplist ordered by score in sync
+type SortSet struct {
+	Dict     HashFloat
+	skiplist *structure.SkipList
+}
+
+func NewSortSet() *SortSet {
+	return &SortSet{
+		Dict:     make(HashFloat),
+		skiplist: structure.NewSkipList(),
+	}
+}
+
+func (z *SortSet) Len() int {
+	return len(z.Dict)
+}
+
+//Add sets the score of member, it returns true if member is new
+func (z *SortSet) Add(member string, score float64) bool {
+	old, found := z.Dict[member]
+	if found {
+		if old == score {
+			return false
+		}
+		z.skiplist.Delete(old, member)
+	}
+	z.Dict[member] = score
+	z.skiplist.Set(score, member)
+	return !found
+}
+
+func (z *SortSet) Remove(member string) bool {
+	old, found := z.Dict[member]
+	if !found {
+		return false
+	}
+	delete(z.Dict, member)
+	z.skiplist.Delete(old, member)
+	return true
+}
+
+//snapshotObject is the on-disk form of an Object
+type snapshotObject struct {
+	Type ObjectType
+	Str  []byte
+	List [][]byte
+	Set  []string
+	//Intset holds the members of a set using the intset encoding, Set is
+	//then empty
+	Intset []int64 `msgpack:",omitempty"`
+	Zset HashFloat
+	Hash HashValue
+	FieldExpires map[string]int64 `msgpack:",omitempty"`
+}
+
+func (o *Object) snapshot() *snapshotObject {
+	so := &snapshotObject{Type: o.Type}
+	switch o.Type {
+	case ObjString:
+		so.Str = o.Str
+	case ObjList:
+		so.List = o.List.Values()
+	case ObjSet:
+		if o.Set.Encoding() == structure.EncodingIntset {
+			so.Intset = o.Set.Ints()
+			break
+		}
+		for _, v := range *o.Set.Members() {
+			so.Set = append(so.Set, string(v))
+		}
+	case ObjZset:
+		so.Zset = o.Zset.Dict
+	case ObjHash:
+		so.Hash = o.Hash
+		so.FieldExpires = o.FieldExpires
+	}
+	return so
+}
+
+//object builds the Object, sets of integers keep the intset encoding up to maxIntset members
+func (so *snapshotObject) object(key string, maxIntset int) *Object {
+	switch so.Type {
+	case ObjString:
+		return newStringObject(so.Str)
+	case ObjList:
+		o := newListObject()
+		o.List.Add(so.List...)
+		return o
+	case ObjSet:
+		if len(so.Set) == 0 {
+			return &Object{Type: ObjSet, Set: structure.NewIntset(key, so.Intset, maxIntset)}
+		}
+		o := &Object{Type: ObjSet, Set: structure.NewHashSset(key)}
+		for _, v := range so.Set {
+			o.Set.Add(v)
+		}
+		return o
+	case ObjZset:
+		o := newZsetObject()
+		for member, score := range so.Zset {
+			o.Zset.Add(member, score)
+		}
+		return o
+	case ObjHash:
+		o := newHashObject()
+		for k, v := range so.Hash {
+			o.Hash[k] = v
+		}
+		if len(so.FieldExpires) > 0 {
+			o.FieldExpires = so.FieldExpires
+		}
+		return o
+	}
+	return nil
+}
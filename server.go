@@ -8,6 +8,7 @@ import (
 	"net"
 	"strconv"
 	"sync"
+	"time"
 )
 
 var (
@@ -90,6 +91,10 @@ type Conn interface {
 	PeekPipeline() []Command
 	// NetConn returns the base net.Conn connection
 	NetConn() net.Conn
+	// SelectedDB returns the index of the database selected by the client.
+	SelectedDB() int
+	// SelectDB changes the database selected by the client.
+	SelectDB(index int)
 }
 
 // NewServerNetworkType returns a new Redcon server. The network net must be
@@ -105,8 +110,14 @@ func NewServerNetwork(
 		closed: closed,
 		conns:  make(map[*conn]bool),
 	}
-	s.db = NewMemdb(s)
+	s.dbs = make([]*Memdb, config.databases)
+	for i := range s.dbs {
+		s.dbs[i] = NewMemdb(s, i)
+	}
 	InitNewWal(s)
+	for _, db := range s.dbs {
+		go db.activeExpire(100 * time.Millisecond)
+	}
 	return s
 }
 
@@ -270,6 +281,7 @@ type conn struct {
 	detached bool
 	closed   bool
 	cmds     []Command
+	db       int
 }
 
 func (c *conn) Close() error {
@@ -301,6 +313,12 @@ func (c *conn) PeekPipeline() []Command {
 func (c *conn) NetConn() net.Conn {
 	return c.conn
 }
+func (c *conn) SelectedDB() int {
+	return c.db
+}
+func (c *conn) SelectDB(index int) {
+	c.db = index
+}
 
 // BaseWriter returns the underlying connection writer, if any
 func BaseWriter(c Conn) *Writer {
@@ -383,7 +401,7 @@ type Server struct {
 	conns   map[*conn]bool
 	ln      net.Listener
 	done    bool
-	db      *Memdb
+	dbs     []*Memdb
 	w       *Wal
 }
 
@@ -0,0 +1,122 @@
+package newredis
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/widaT/yagowal/structure"
+)
+
+//testConn records the replies written to it, one string per reply in a
+//redis-cli like form: +OK, :1, $value, *2, (nil) or the error message
+type testConn struct {
+	Conn
+	db      int
+	replies []string
+}
+
+func (c *testConn) WriteError(msg string)       { c.replies = append(c.replies, msg) }
+func (c *testConn) WriteString(str string)      { c.replies = append(c.replies, "+"+str) }
+func (c *testConn) WriteBulk(bulk []byte)       { c.replies = append(c.replies, "$"+string(bulk)) }
+func (c *testConn) WriteBulkString(bulk string) { c.replies = append(c.replies, "$"+bulk) }
+func (c *testConn) WriteInt(num int)            { c.replies = append(c.replies, fmt.Sprintf(":%d", num)) }
+func (c *testConn) WriteInt64(num int64)        { c.replies = append(c.replies, fmt.Sprintf(":%d", num)) }
+func (c *testConn) WriteArray(count int)        { c.replies = append(c.replies, fmt.Sprintf("*%d", count)) }
+func (c *testConn) WriteNull()                  { c.replies = append(c.replies, "(nil)") }
+func (c *testConn) SelectedDB() int             { return c.db }
+func (c *testConn) SelectDB(index int)          { c.db = index }
+
+//newTestServer returns a server without network and wal files, the wal
+//entries are kept in ents as with the es mode
+func newTestServer() *Server {
+	ents = nil
+	return newReplayServer()
+}
+
+func newReplayServer() *Server {
+	return newConfigServer(DefaultConfig().OpenWal("es"))
+}
+
+//newConfigServer returns a server using conf, without network and wal files
+func newConfigServer(conf *Config) *Server {
+	s := &Server{conf: conf}
+	s.dbs = make([]*Memdb, conf.databases)
+	for i := range s.dbs {
+		s.dbs[i] = NewMemdb(s, i)
+	}
+	s.w = &Wal{s: s, snapcount: conf.snapCount}
+	return s
+}
+
+//do runs the command line, arguments are separated by spaces and "" is an
+//empty one, and returns the replies separated by spaces
+func do(s *Server, c *testConn, line string) string {
+	c.replies = nil
+	var args [][]byte
+	for _, arg := range strings.Fields(line) {
+		//"" stands for an empty argument
+		if arg == `""` {
+			arg = ""
+		}
+		args = append(args, []byte(arg))
+	}
+	DoCmd(s, c, Command{Args: args})
+	return strings.Join(c.replies, " ")
+}
+
+type cmdCase struct {
+	cmd  string
+	want string
+}
+
+//runCases runs the commands in order on a single connection
+func runCases(t *testing.T, s *Server, cases []cmdCase) {
+	t.Helper()
+	c := &testConn{}
+	for _, tc := range cases {
+		if got := do(s, c, tc.cmd); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.cmd, got, tc.want)
+		}
+	}
+}
+
+//replay builds a new server from the wal entries written so far
+func replay(t *testing.T) *Server {
+	t.Helper()
+	r := newReplayServer()
+	r.w.applyEntries(append([]structure.Entry(nil), ents...))
+	return r
+}
+
+//keyspace returns the content of the database in a comparable form
+func keyspace(m *Memdb) *memdbSnapshot {
+	snap := m.snapshot()
+	for _, so := range snap.Keys {
+		sort.Strings(so.Set)
+	}
+	return snap
+}
+
+//assertSameKeyspace checks that all the databases of a and b hold the same keys
+func assertSameKeyspace(t *testing.T, a, b *Server) {
+	t.Helper()
+	for i := range a.dbs {
+		ka, kb := keyspace(a.dbs[i]), keyspace(b.dbs[i])
+		for key, so := range ka.Keys {
+			if !reflect.DeepEqual(so, kb.Keys[key]) {
+				t.Errorf("db %d key %s: %+v, replayed %+v", i, key, so, kb.Keys[key])
+			}
+		}
+		for key := range kb.Keys {
+			if _, found := ka.Keys[key]; !found {
+				t.Errorf("db %d key %s only exists after replay", i, key)
+			}
+		}
+		if !reflect.DeepEqual(ka.Expires, kb.Expires) {
+			t.Errorf("db %d expires %v, replayed %v", i, ka.Expires, kb.Expires)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package structure
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 )
@@ -342,6 +343,140 @@ func (list *List) Insert(index int, values ...[]byte) {
 	}
 }
 
+// Set replaces the value at index, it returns false if index is out of range.
+func (list *List) Set(index int, value []byte) bool {
+	e := list.GetIndex(index)
+	if e == nil {
+		return false
+	}
+	e.value = value
+	return true
+}
+
+// InsertPivot inserts value before or after the first element equal to pivot,
+// it returns false if pivot is not found.
+func (list *List) InsertPivot(pivot, value []byte, after bool) bool {
+	for e := list.first; e != nil; e = e.next {
+		if !bytes.Equal(e.value, pivot) {
+			continue
+		}
+		n := &element{value: value}
+		if after {
+			n.prev, n.next = e, e.next
+		} else {
+			n.prev, n.next = e.prev, e
+		}
+		if n.prev != nil {
+			n.prev.next = n
+		} else {
+			list.first = n
+		}
+		if n.next != nil {
+			n.next.prev = n
+		} else {
+			list.last = n
+		}
+		list.size++
+		return true
+	}
+	return false
+}
+
+// unlink removes e from the list.
+func (list *List) unlink(e *element) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		list.first = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		list.last = e.prev
+	}
+	e.prev, e.next = nil, nil
+	list.size--
+}
+
+// RemoveValue removes up to count elements equal to value, starting from the
+// head when count is positive and from the tail when it is negative. A count
+// of 0 removes all of them. It returns the number of removed elements.
+func (list *List) RemoveValue(value []byte, count int) int {
+	removed := 0
+	if count >= 0 {
+		for e := list.first; e != nil && (count == 0 || removed < count); {
+			next := e.next
+			if bytes.Equal(e.value, value) {
+				list.unlink(e)
+				removed++
+			}
+			e = next
+		}
+		return removed
+	}
+	for e := list.last; e != nil && removed < -count; {
+		prev := e.prev
+		if bytes.Equal(e.value, value) {
+			list.unlink(e)
+			removed++
+		}
+		e = prev
+	}
+	return removed
+}
+
+// Trim keeps only the elements between start and stop included, the list is
+// emptied when the range is empty.
+func (list *List) Trim(start, stop int) {
+	if stop >= list.size {
+		stop = list.size - 1
+	}
+	if start < 0 || start > stop {
+		list.Clear()
+		return
+	}
+	first, last := list.GetIndex(start), list.GetIndex(stop)
+	first.prev, last.next = nil, nil
+	list.first, list.last = first, last
+	list.size = stop - start + 1
+}
+
+// Positions returns the indexes of the elements equal to value. With a
+// positive rank the search starts from the head skipping the first rank-1
+// matches, with a negative rank from the tail. At most count indexes are
+// returned (0 means all) and at most maxlen elements are compared (0 means
+// the whole list).
+func (list *List) Positions(value []byte, rank, count, maxlen int) []int {
+	var ret []int
+	skip := rank - 1
+	step := 1
+	e, index := list.first, 0
+	if rank < 0 {
+		skip = -rank - 1
+		step = -1
+		e, index = list.last, list.size-1
+	}
+	for compared := 0; e != nil && (maxlen == 0 || compared < maxlen); compared++ {
+		if bytes.Equal(e.value, value) {
+			if skip > 0 {
+				skip--
+			} else {
+				ret = append(ret, index)
+				if count != 0 && len(ret) == count {
+					break
+				}
+			}
+		}
+		if step > 0 {
+			e = e.next
+		} else {
+			e = e.prev
+		}
+		index += step
+	}
+	return ret
+}
+
 // String returns a string representation of container
 func (list *List) String() string {
 	str := "DoublyLinkedList\n"
@@ -1,84 +1,269 @@
 package structure
 
 import (
-	"sync"
 	"math/rand"
-	"time"
+	"sort"
+	"strconv"
 )
 
+const (
+	EncodingIntset    = "intset"
+	EncodingHashtable = "hashtable"
+)
+
+// Set is encoded as a sorted slice of integers while all of its members are
+// integers and there are at most maxIntset of them, otherwise its
+// members are kept in a slice as well as in a map from member to position,
+// so that a random member can be picked in O(1). A set never goes back to
+// the intset encoding. It is protected by the lock of the db.
 type Set struct {
-	mu   sync.Mutex
-	Key  string
-	Mset map[string]struct{}
+	Key       string
+	ints      []int64
+	maxIntset int
+	Mset      map[string]int
+	members   []string
+}
+
+// setInt parses member as an integer member of an intset, only the canonical
+// form is accepted so that the member reads back unchanged.
+func setInt(member string) (int64, bool) {
+	if len(member) == 0 || len(member) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != member {
+		return 0, false
+	}
+	return v, true
+}
+
+func (s *Set) isIntset() bool {
+	return s.Mset == nil
+}
+
+// Encoding returns EncodingIntset or EncodingHashtable.
+func (s *Set) Encoding() string {
+	if s.isIntset() {
+		return EncodingIntset
+	}
+	return EncodingHashtable
+}
+
+// Ints returns the members of an intset in ascending order, nil for a
+// hashtable.
+func (s *Set) Ints() []int64 {
+	return s.ints
+}
+
+// search returns the position of v in the intset, or where it would be inserted.
+func (s *Set) search(v int64) (int, bool) {
+	i := sort.Search(len(s.ints), func(i int) bool { return s.ints[i] >= v })
+	return i, i < len(s.ints) && s.ints[i] == v
+}
+
+// convert switches the set to the hashtable encoding.
+func (s *Set) convert() {
+	if !s.isIntset() {
+		return
+	}
+	s.Mset = make(map[string]int, len(s.ints))
+	s.members = make([]string, len(s.ints))
+	for i, v := range s.ints {
+		key := strconv.FormatInt(v, 10)
+		s.Mset[key] = i
+		s.members[i] = key
+	}
+	s.ints = nil
 }
 
 func (s *Set) Len() int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return len(s.Mset)
+	if s.isIntset() {
+		return len(s.ints)
+	}
+	return len(s.members)
 }
 
-func (s *Set) Add(key string) int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// at returns the i-th member in the order of the encoding.
+func (s *Set) at(i int) string {
+	if s.isIntset() {
+		return strconv.FormatInt(s.ints[i], 10)
+	}
+	return s.members[i]
+}
 
-	if _, found := s.Mset[key]; !found {
-		s.Mset[key] = struct{}{}
-	} else {
+func (s *Set) Add(key string) int {
+	if s.isIntset() {
+		if v, ok := setInt(key); ok {
+			i, found := s.search(v)
+			if found {
+				return 0
+			}
+			if len(s.ints) < s.maxIntset {
+				s.ints = append(s.ints, 0)
+				copy(s.ints[i+1:], s.ints[i:])
+				s.ints[i] = v
+				return 1
+			}
+		}
+		s.convert()
+	}
+	if _, found := s.Mset[key]; found {
 		return 0
 	}
+	s.Mset[key] = len(s.members)
+	s.members = append(s.members, key)
 	return 1
 }
 
+// Del removes key, the last member takes its place in the slice.
 func (s *Set) Del(key string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.Mset, key)
+	s.del(key)
 	return nil
 }
 
-func (s *Set) Members() *[][]byte {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	var ret [][]byte
+func (s *Set) del(key string) bool {
+	if s.isIntset() {
+		v, ok := setInt(key)
+		if !ok {
+			return false
+		}
+		i, found := s.search(v)
+		if !found {
+			return false
+		}
+		s.ints = append(s.ints[:i], s.ints[i+1:]...)
+		return true
+	}
+	i, found := s.Mset[key]
+	if !found {
+		return false
+	}
+	last := len(s.members) - 1
+	s.members[i] = s.members[last]
+	s.Mset[s.members[i]] = i
+	s.members[last] = ""
+	s.members = s.members[:last]
+	delete(s.Mset, key)
+	return true
+}
+
+// Remove deletes key and returns 1 if it was a member.
+func (s *Set) Remove(key string) int {
+	if s.del(key) {
+		return 1
+	}
+	return 0
+}
 
-	for key, _ := range s.Mset {
-		ret = append(ret, []byte(key))
+func (s *Set) Members() *[][]byte {
+	ret := make([][]byte, s.Len())
+	for i := range ret {
+		ret[i] = []byte(s.at(i))
 	}
 	return &ret
 }
 
 func (s *Set) Exists(key string) int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
+	if s.isIntset() {
+		if v, ok := setInt(key); ok {
+			if _, found := s.search(v); found {
+				return 1
+			}
+		}
+		return 0
+	}
 	if _, found := s.Mset[key]; found {
 		return 1
 	}
 	return 0
 }
 
+// RandomKey returns a random member, "" if the set is empty.
 func (s *Set) RandomKey() string {
-	var keys []string
-	for k, _ := range s.Mset {
-		keys = append(keys, k)
-	}
-	len := len(keys)
-	if len == 0 {
+	size := s.Len()
+	if size == 0 {
 		return ""
 	}
+	return s.at(rand.Intn(size))
+}
+
+// RandomKeys returns count distinct random members, or all of them when
+// count is not smaller than the size of the set.
+func (s *Set) RandomKeys(count int) []string {
+	size := s.Len()
+	if count >= size {
+		count = size
+	}
+	idx := make([]int, 0, count)
+	if count == size {
+		for i := 0; i < size; i++ {
+			idx = append(idx, i)
+		}
+	} else if count*3 > size {
+		// 取的数量接近全部的时候，打乱一份下标的前count个
+		perm := make([]int, size)
+		for i := range perm {
+			perm[i] = i
+		}
+		for i := 0; i < count; i++ {
+			j := i + rand.Intn(size-i)
+			perm[i], perm[j] = perm[j], perm[i]
+		}
+		idx = perm[:count]
+	} else {
+		picked := make(map[int]struct{}, count)
+		for len(idx) < count {
+			i := rand.Intn(size)
+			if _, found := picked[i]; found {
+				continue
+			}
+			picked[i] = struct{}{}
+			idx = append(idx, i)
+		}
+	}
+	keys := make([]string, len(idx))
+	for i, j := range idx {
+		keys[i] = s.at(j)
+	}
+	return keys
+}
 
-	if len == 1 {
-		return keys[0]
+// Dup returns a copy of the set with the same encoding.
+func (s *Set) Dup(key string) *Set {
+	n := &Set{Key: key, maxIntset: s.maxIntset}
+	if s.isIntset() {
+		n.ints = append([]int64(nil), s.ints...)
+		return n
 	}
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	index := r.Intn(len - 1)
-	return keys[index]
+	n.convert()
+	for _, member := range s.members {
+		n.Add(member)
+	}
+	return n
+}
+
+// NewSset returns an empty set, it stays an intset while it holds at most
+// maxIntset integers.
+func NewSset(key string, maxIntset int) *Set {
+	return &Set{
+		Key:       key,
+		maxIntset: maxIntset,
+	}
+}
+
+// NewHashSset returns an empty set that uses the hashtable encoding.
+func NewHashSset(key string) *Set {
+	s := NewSset(key, 0)
+	s.convert()
+	return s
 }
 
-func NewSset(key string) *Set {
+// NewIntset returns a set holding the integers ints, which must be sorted and
+// distinct. It uses the intset encoding even above maxIntset.
+func NewIntset(key string, ints []int64, maxIntset int) *Set {
 	return &Set{
-		Key:  key,
-		Mset: make(map[string]struct{}),
+		Key:       key,
+		ints:      append([]int64(nil), ints...),
+		maxIntset: maxIntset,
 	}
 }
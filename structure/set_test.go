@@ -0,0 +1,134 @@
+package structure
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func sortedMembers(s *Set) []string {
+	var members []string
+	for _, m := range *s.Members() {
+		members = append(members, string(m))
+	}
+	sort.Strings(members)
+	return members
+}
+
+func TestSetEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		add      []string
+		remove   []string
+		encoding string
+		members  []string
+		added    int
+	}{
+		{"integers", 512, []string{"3", "-1", "2", "3"}, nil, EncodingIntset, []string{"-1", "2", "3"}, 3},
+		{"int64 bounds", 512, []string{"9223372036854775807", "-9223372036854775808"}, nil, EncodingIntset, []string{"-9223372036854775808", "9223372036854775807"}, 2},
+		{"out of int64", 512, []string{"1", "9223372036854775808"}, nil, EncodingHashtable, []string{"1", "9223372036854775808"}, 2},
+		{"not canonical", 512, []string{"1", "01"}, nil, EncodingHashtable, []string{"01", "1"}, 2},
+		{"negative zero", 512, []string{"-0"}, nil, EncodingHashtable, []string{"-0"}, 1},
+		{"plus sign", 512, []string{"+1"}, nil, EncodingHashtable, []string{"+1"}, 1},
+		{"string", 512, []string{"1", "a"}, nil, EncodingHashtable, []string{"1", "a"}, 2},
+		{"empty member", 512, []string{""}, nil, EncodingHashtable, []string{""}, 1},
+		{"at the limit", 3, []string{"1", "2", "3", "3"}, nil, EncodingIntset, []string{"1", "2", "3"}, 3},
+		{"above the limit", 3, []string{"1", "2", "3", "4"}, nil, EncodingHashtable, []string{"1", "2", "3", "4"}, 4},
+		{"limit 0", 0, []string{"1"}, nil, EncodingHashtable, []string{"1"}, 1},
+		{"remove from intset", 512, []string{"1", "2", "3"}, []string{"2", "a", "02", "4"}, EncodingIntset, []string{"1", "3"}, 3},
+		{"never back to intset", 512, []string{"1", "a"}, []string{"a"}, EncodingHashtable, []string{"1"}, 2},
+	}
+	for _, tt := range tests {
+		s := NewSset("k", tt.limit)
+		added := 0
+		for _, m := range tt.add {
+			added += s.Add(m)
+		}
+		for _, m := range tt.remove {
+			s.Remove(m)
+		}
+		if added != tt.added {
+			t.Errorf("%s: %d members added, want %d", tt.name, added, tt.added)
+		}
+		if s.Encoding() != tt.encoding {
+			t.Errorf("%s: encoding %s, want %s", tt.name, s.Encoding(), tt.encoding)
+		}
+		if got := sortedMembers(s); !reflect.DeepEqual(got, tt.members) {
+			t.Errorf("%s: members %v, want %v", tt.name, got, tt.members)
+		}
+		if s.Len() != len(tt.members) {
+			t.Errorf("%s: len %d, want %d", tt.name, s.Len(), len(tt.members))
+		}
+		for _, m := range tt.members {
+			if s.Exists(m) != 1 {
+				t.Errorf("%s: %q not found", tt.name, m)
+			}
+		}
+		for _, m := range tt.remove {
+			if s.Exists(m) != 0 {
+				t.Errorf("%s: removed %q found", tt.name, m)
+			}
+		}
+		if s.Encoding() == EncodingIntset && !sort.SliceIsSorted(s.Ints(), func(i, j int) bool { return s.Ints()[i] < s.Ints()[j] }) {
+			t.Errorf("%s: intset not sorted %v", tt.name, s.Ints())
+		}
+	}
+}
+
+func TestSetDupKeepsLimit(t *testing.T) {
+	s := NewSset("k", 2)
+	s.Add("1")
+	d := s.Dup("d")
+	if d.Encoding() != EncodingIntset {
+		t.Fatalf("dup encoding %s", d.Encoding())
+	}
+	d.Add("2")
+	if d.Encoding() != EncodingIntset {
+		t.Fatalf("dup converted at the limit")
+	}
+	d.Add("3")
+	if d.Encoding() != EncodingHashtable {
+		t.Fatalf("dup not converted above the limit")
+	}
+	if s.Len() != 1 || s.Encoding() != EncodingIntset {
+		t.Fatalf("original changed by the dup")
+	}
+
+	//a loaded intset stays one above the limit, but converts on the next add
+	i := NewIntset("i", []int64{1, 2, 3}, 2)
+	if i.Encoding() != EncodingIntset || i.Len() != 3 {
+		t.Fatalf("loaded intset %s of %d members", i.Encoding(), i.Len())
+	}
+	i.Add("4")
+	if i.Encoding() != EncodingHashtable {
+		t.Fatalf("loaded intset not converted")
+	}
+}
+
+func TestSetRandomKeys(t *testing.T) {
+	for _, limit := range []int{0, 512} {
+		s := NewSset("k", limit)
+		for i := 0; i < 100; i++ {
+			s.Add(strconv.Itoa(i))
+		}
+		for _, count := range []int{0, 1, 10, 50, 99, 100, 200} {
+			keys := s.RandomKeys(count)
+			want := count
+			if want > 100 {
+				want = 100
+			}
+			seen := make(map[string]bool)
+			for _, k := range keys {
+				if seen[k] || s.Exists(k) != 1 {
+					t.Errorf("%s count %d: bad member %q", s.Encoding(), count, k)
+				}
+				seen[k] = true
+			}
+			if len(keys) != want {
+				t.Errorf("%s count %d: %d members", s.Encoding(), count, len(keys))
+			}
+		}
+	}
+}
@@ -11,6 +11,7 @@ const DefaultMaxLevel = 32
 
 type node struct {
 	forward  []*node
+	span     []int //number of nodes skipped by forward at each level
 	backward *node
 	key      float64
 	value    string
@@ -39,7 +40,9 @@ func (n *node) hasPrevious() bool {
 }
 
 // A SkipList is a map-like data structure that maintains an ordered
-// collection of key-value pairs. Insertion, lookup, and deletion are
+// collection of key-value pairs. Pairs are ordered by key, then pairs with
+// the same key by the bytes of their value, which gives the lexicographic
+// order of sorted set members that share a score. Insertion, lookup, and deletion are
 // all O(log n) operations. A SkipList can efficiently store up to
 // 2^MaxLevel items.
 //
@@ -272,6 +275,41 @@ func (i *indexRangeIterator) Previous() bool {
 	return true
 }
 
+// revIndexRangeIterator walks backward from index to lowerLimit.
+type revIndexRangeIterator struct {
+	iter
+	index      int
+	lowerLimit int
+}
+
+func (i *revIndexRangeIterator) Close() {
+	i.iter.Close()
+	i.index = 0
+	i.lowerLimit = 0
+}
+
+func (i *revIndexRangeIterator) Next() bool {
+	if i.index <= i.lowerLimit || i.current.backward == nil {
+		return false
+	}
+	i.current = i.current.backward
+	i.key = i.current.key
+	i.value = i.current.value
+	i.index--
+	return true
+}
+
+func (i *revIndexRangeIterator) Previous() bool {
+	if !i.current.hasNext() {
+		return false
+	}
+	i.current = i.current.next()
+	i.key = i.current.key
+	i.value = i.current.value
+	i.index++
+	return true
+}
+
 // Iterator returns an Iterator that will go through all elements s.
 func (s *SkipList) Iterator() Iterator {
 	return &iter{
@@ -352,10 +390,7 @@ func (s *SkipList) Range(from, to float64) Iterator {
 }
 
 func (s *SkipList) IndexRange(l, u int) Iterator {
-	n := s.header
-	for i := 0; i <= l; i++ {
-		n = n.next()
-	}
+	n := s.getByRank(l + 1)
 	return &indexRangeIterator{
 		iter: iter{
 			current: &node{
@@ -370,6 +405,37 @@ func (s *SkipList) IndexRange(l, u int) Iterator {
 	}
 }
 
+// RevIndexRange returns an iterator going from the element at index u down to
+// the element at index l.
+func (s *SkipList) RevIndexRange(u, l int) Iterator {
+	n := s.getByRank(u + 1)
+	return &revIndexRangeIterator{
+		iter: iter{
+			current: &node{
+				backward: n,
+			},
+			list: s,
+		},
+		index:      u + 1,
+		lowerLimit: l,
+	}
+}
+
+// CountWhile returns the number of elements at the head of s for which fn
+// returns true. fn must hold for a prefix of the list and not after it, the
+// spans of the links make it O(log n).
+func (s *SkipList) CountWhile(fn func(key float64, value string) bool) int {
+	rank := 0
+	current := s.header
+	for i := s.level(); i >= 0; i-- {
+		for current.forward[i] != nil && fn(current.forward[i].key, current.forward[i].value) {
+			rank += current.span[i]
+			current = current.forward[i]
+		}
+	}
+	return rank
+}
+
 func (s *SkipList) level() int {
 	return len(s.header.forward) - 1
 }
@@ -436,11 +502,29 @@ func (s *SkipList) getPath(current *node, update []*node, key float64, value str
 	return current.next()
 }
 
+// less reports whether n comes before the element key, value.
+func (n *node) less(key float64, value string) bool {
+	return n.key < key || (n.key == key && n.value < value)
+}
+
 // Sets set the value associated with key in s.
 func (s *SkipList) Set(key float64, value string) {
 	// s.level starts from 0, so we need to allocate one.
 	update := make([]*node, s.level()+1, s.effectiveMaxLevel()+1)
-	candidate := s.getPath(s.header, update, key, value)
+	// rank[i] is the rank of update[i], the header having rank 0.
+	rank := make([]int, s.level()+1, s.effectiveMaxLevel()+1)
+	current := s.header
+	for i := s.level(); i >= 0; i-- {
+		if i < s.level() {
+			rank[i] = rank[i+1]
+		}
+		for current.forward[i] != nil && current.forward[i].less(key, value) {
+			rank[i] += current.span[i]
+			current = current.forward[i]
+		}
+		update[i] = current
+	}
+	candidate := current.next()
 
 	if candidate != nil && candidate.key == key && candidate.value == value {
 		return
@@ -448,18 +532,22 @@ func (s *SkipList) Set(key float64, value string) {
 
 	newLevel := s.randomLevel()
 
-	if currentLevel := s.level(); newLevel > currentLevel {
+	currentLevel := s.level()
+	if newLevel > currentLevel {
 		// there are no pointers for the higher levels in
 		// update. Header should be there. Also add higher
-		// level links to the header.
+		// level links to the header, spanning the whole list.
 		for i := currentLevel + 1; i <= newLevel; i++ {
 			update = append(update, s.header)
+			rank = append(rank, 0)
 			s.header.forward = append(s.header.forward, nil)
+			s.header.span = append(s.header.span, s.length)
 		}
 	}
 
 	newNode := &node{
 		forward: make([]*node, newLevel+1, s.effectiveMaxLevel()+1),
+		span:    make([]int, newLevel+1, s.effectiveMaxLevel()+1),
 		key:     key,
 		value:   value,
 	}
@@ -470,17 +558,21 @@ func (s *SkipList) Set(key float64, value string) {
 	for i := 0; i <= newLevel; i++ {
 		newNode.forward[i] = update[i].forward[i]
 		update[i].forward[i] = newNode
+		// update[i] used to skip update[i].span[i] nodes, now newNode sits
+		// rank[0]-rank[i]+1 nodes after it.
+		newNode.span[i] = update[i].span[i] - (rank[0] - rank[i])
+		update[i].span[i] = rank[0] - rank[i] + 1
+	}
+	// the links above newNode now jump over one more node
+	for i := newLevel + 1; i <= currentLevel; i++ {
+		update[i].span[i]++
 	}
 
 	s.length++
 
 	if newNode.forward[0] != nil {
-		if newNode.forward[0].backward != newNode {
-			newNode.forward[0].backward = newNode
-		}
-	}
-
-	if s.footer == nil || s.footer.key < key {
+		newNode.forward[0].backward = newNode
+	} else {
 		s.footer = newNode
 	}
 }
@@ -518,13 +610,16 @@ func (s *SkipList) Delete(key float64, value string) (ok bool) {
 	update := make([]*node, s.level()+1, s.effectiveMaxLevel())
 	candidate := s.getPath(s.header, update, key, value)
 
-	if candidate == nil || candidate.value != value {
+	if candidate == nil || candidate.key != key || candidate.value != value {
 		return false
 	}
 
 	previous := candidate.backward
 	if s.footer == candidate {
 		s.footer = previous
+		if previous == s.header {
+			s.footer = nil
+		}
 	}
 
 	next := candidate.next()
@@ -532,18 +627,62 @@ func (s *SkipList) Delete(key float64, value string) (ok bool) {
 		next.backward = previous
 	}
 
-	for i := 0; i <= s.level() && update[i].forward[i] == candidate; i++ {
-		update[i].forward[i] = candidate.forward[i]
+	for i := 0; i <= s.level(); i++ {
+		if update[i].forward[i] == candidate {
+			update[i].span[i] += candidate.span[i] - 1
+			update[i].forward[i] = candidate.forward[i]
+		} else {
+			update[i].span[i]--
+		}
 	}
 
 	for s.level() > 0 && s.header.forward[s.level()] == nil {
 		s.header.forward = s.header.forward[:s.level()]
+		s.header.span = s.header.span[:len(s.header.forward)]
 	}
 	s.length--
 
 	return true
 }
 
+// Rank returns the 1-based position of the element key, value in s, 0 if it
+// is not present. It takes O(log n) using the spans of the links.
+func (s *SkipList) Rank(key float64, value string) int {
+	rank := 0
+	current := s.header
+	for i := s.level(); i >= 0; i-- {
+		for current.forward[i] != nil && (current.forward[i].less(key, value) ||
+			(current.forward[i].key == key && current.forward[i].value == value)) {
+			rank += current.span[i]
+			current = current.forward[i]
+		}
+		if current != s.header && current.key == key && current.value == value {
+			return rank
+		}
+	}
+	return 0
+}
+
+// getByRank returns the node at the 1-based position rank, nil if rank is
+// out of range.
+func (s *SkipList) getByRank(rank int) *node {
+	if rank < 1 || rank > s.length {
+		return nil
+	}
+	traversed := 0
+	current := s.header
+	for i := s.level(); i >= 0; i-- {
+		for current.forward[i] != nil && traversed+current.span[i] <= rank {
+			traversed += current.span[i]
+			current = current.forward[i]
+		}
+		if traversed == rank {
+			return current
+		}
+	}
+	return nil
+}
+
 // Ordered is an interface which can be linearly ordered by the
 // LessThan method, whereby this instance is deemed to be less than
 // other. Additionally, Ordered instances should behave properly when
@@ -559,6 +698,7 @@ func NewSkipList() *SkipList {
 	return &SkipList{
 		header: &node{
 			forward: []*node{nil},
+			span:    []int{0},
 		},
 		MaxLevel: DefaultMaxLevel,
 	}
@@ -0,0 +1,251 @@
+package structure
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+type entry struct {
+	key   float64
+	value string
+}
+
+func entryLess(a, b entry) bool {
+	return a.key < b.key || (a.key == b.key && a.value < b.value)
+}
+
+//checkSkipList compares s with the sorted elements want using linear walks:
+//the order, the back links, the footer and the span of every link
+func checkSkipList(t *testing.T, s *SkipList, want []entry) {
+	t.Helper()
+	if s.Len() != len(want) {
+		t.Fatalf("length %d, want %d", s.Len(), len(want))
+	}
+	rank := map[*node]int{s.header: 0}
+	prev := s.header
+	i := 0
+	for n := s.header.next(); n != nil; n = n.next() {
+		if i >= len(want) || n.key != want[i].key || n.value != want[i].value {
+			t.Fatalf("element %d is %v %q", i, n.key, n.value)
+		}
+		if n.backward != prev && !(prev == s.header && n.backward == nil) {
+			t.Fatalf("wrong back link of element %d", i)
+		}
+		i++
+		rank[n] = i
+		prev = n
+	}
+	if i != len(want) {
+		t.Fatalf("%d elements, want %d", i, len(want))
+	}
+	if (len(want) == 0 && s.footer != nil) || (len(want) > 0 && s.footer != prev) {
+		t.Fatalf("wrong footer")
+	}
+	for level := 0; level <= s.level(); level++ {
+		for n := s.header; n != nil; n = n.forward[level] {
+			if len(n.span) != len(n.forward) {
+				t.Fatalf("node of rank %d has %d spans for %d links", rank[n], len(n.span), len(n.forward))
+			}
+			//a link to the end counts the nodes left, like in redis
+			want := len(want) - rank[n]
+			if next := n.forward[level]; next != nil {
+				want = rank[next] - rank[n]
+			}
+			if n.span[level] != want {
+				t.Fatalf("span of node of rank %d at level %d is %d, want %d", rank[n], level, n.span[level], want)
+			}
+		}
+	}
+	if s.level() > 0 && s.header.forward[s.level()] == nil {
+		t.Fatalf("empty top level %d", s.level())
+	}
+}
+
+//checkQueries compares the rank based queries with the sorted elements want
+func checkQueries(t *testing.T, s *SkipList, want []entry, rnd *rand.Rand) {
+	t.Helper()
+	for i, e := range want {
+		if r := s.Rank(e.key, e.value); r != i+1 {
+			t.Fatalf("rank of %v %q is %d, want %d", e.key, e.value, r, i+1)
+		}
+		if n := s.getByRank(i + 1); n == nil || n.key != e.key || n.value != e.value {
+			t.Fatalf("wrong element at rank %d", i+1)
+		}
+	}
+	if r := s.Rank(-1, "missing"); r != 0 {
+		t.Fatalf("rank of a missing element is %d", r)
+	}
+	if s.getByRank(0) != nil || s.getByRank(len(want)+1) != nil {
+		t.Fatalf("element found out of range")
+	}
+	if len(want) == 0 {
+		return
+	}
+	l := rnd.Intn(len(want))
+	u := l + rnd.Intn(len(want)-l)
+	var got []entry
+	for it := s.IndexRange(l, u); it.Next(); {
+		got = append(got, entry{it.Key(), it.Value()})
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want[l:u+1]) {
+		t.Fatalf("IndexRange(%d, %d) = %v, want %v", l, u, got, want[l:u+1])
+	}
+	got = got[:0]
+	for it := s.RevIndexRange(u, l); it.Next(); {
+		got = append([]entry{{it.Key(), it.Value()}}, got...)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want[l:u+1]) {
+		t.Fatalf("RevIndexRange(%d, %d) = %v, want %v", u, l, got, want[l:u+1])
+	}
+	pivot := want[rnd.Intn(len(want))]
+	n := s.CountWhile(func(key float64, value string) bool {
+		return entryLess(entry{key, value}, pivot)
+	})
+	if wantN := sort.Search(len(want), func(i int) bool { return !entryLess(want[i], pivot) }); n != wantN {
+		t.Fatalf("CountWhile before %v = %d, want %d", pivot, n, wantN)
+	}
+}
+
+//TestSkipListSpans applies random inserts, deletes and score updates and
+//checks the list against a sorted slice after each of them
+func TestSkipListSpans(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for round, size := range []int{10, 100, 1000} {
+		s := NewSkipList()
+		scores := make(map[string]float64)
+		for op := 0; op < 3000; op++ {
+			//few distinct scores so that many elements share one
+			member := fmt.Sprintf("m%d", rnd.Intn(size))
+			score := float64(rnd.Intn(size / 5))
+			old, found := scores[member]
+			switch {
+			case !found:
+				s.Set(score, member)
+				scores[member] = score
+			case rnd.Intn(3) == 0:
+				if !s.Delete(old, member) {
+					t.Fatalf("round %d: %q not deleted", round, member)
+				}
+				delete(scores, member)
+			default:
+				//a score update, as SortSet.Add does it
+				s.Delete(old, member)
+				s.Set(score, member)
+				scores[member] = score
+			}
+			//setting an element that exists changes nothing
+			if score, found := scores[member]; found {
+				s.Set(score, member)
+			}
+			if s.Len() != len(scores) {
+				t.Fatalf("round %d: length %d after setting an existing element", round, s.Len())
+			}
+			if s.Delete(score+0.5, member) {
+				t.Fatalf("round %d: missing element deleted", round)
+			}
+			if op%10 != 0 && op != 2999 {
+				continue
+			}
+			want := make([]entry, 0, len(scores))
+			for member, score := range scores {
+				want = append(want, entry{score, member})
+			}
+			sort.Slice(want, func(i, j int) bool { return entryLess(want[i], want[j]) })
+			checkSkipList(t, s, want)
+			checkQueries(t, s, want, rnd)
+		}
+		//drain the list
+		for member, score := range scores {
+			s.Delete(score, member)
+		}
+		checkSkipList(t, s, nil)
+	}
+}
+
+//benchmarkSizes are the list sizes used by the benchmarks
+var benchmarkSizes = []int{1000, 10000, 100000, 1000000}
+
+var benchmarkLists = make(map[int]*SkipList)
+
+func benchmarkList(n int) *SkipList {
+	if s, found := benchmarkLists[n]; found {
+		return s
+	}
+	s := NewSkipList()
+	for i := 0; i < n; i++ {
+		s.Set(float64(i), fmt.Sprint(i))
+	}
+	benchmarkLists[n] = s
+	return s
+}
+
+//walkRank is the rank lookup without spans, a walk of the bottom level
+func walkRank(s *SkipList, key float64, value string) int {
+	rank := 0
+	for n := s.header.next(); n != nil; n = n.next() {
+		rank++
+		if n.key == key && n.value == value {
+			return rank
+		}
+	}
+	return 0
+}
+
+func BenchmarkRank(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		s := benchmarkList(n)
+		rnd := rand.New(rand.NewSource(1))
+		b.Run(fmt.Sprintf("span/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				v := rnd.Intn(n)
+				if s.Rank(float64(v), fmt.Sprint(v)) != v+1 {
+					b.Fatal("wrong rank")
+				}
+			}
+		})
+		if n > 100000 {
+			continue
+		}
+		b.Run(fmt.Sprintf("walk/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				v := rnd.Intn(n)
+				if walkRank(s, float64(v), fmt.Sprint(v)) != v+1 {
+					b.Fatal("wrong rank")
+				}
+			}
+		})
+	}
+}
+
+//BenchmarkIndexRange reads 10 elements from a random index, the seek is
+//compared with the walk of the bottom level IndexRange used before spans
+func BenchmarkIndexRange(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		s := benchmarkList(n)
+		rnd := rand.New(rand.NewSource(1))
+		b.Run(fmt.Sprintf("span/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				l := rnd.Intn(n - 10)
+				for it := s.IndexRange(l, l+9); it.Next(); {
+				}
+			}
+		})
+		if n > 100000 {
+			continue
+		}
+		b.Run(fmt.Sprintf("walk/n=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				l := rnd.Intn(n - 10)
+				current := s.header
+				for j := 0; j <= l; j++ {
+					current = current.next()
+				}
+				for j := 0; j < 10 && current != nil; j++ {
+					current = current.next()
+				}
+			}
+		})
+	}
+}
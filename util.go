@@ -0,0 +1,190 @@
+package newredis
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+//longDoublePrec is the mantissa size of the x87 long double redis uses for INCRBYFLOAT
+const longDoublePrec = 64
+
+//string2ll parses b as a 64 bit integer with the rules of redis: no spaces,
+//no '+' sign and no leading zeros
+func string2ll(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 20 {
+		return 0, false
+	}
+	if len(b) == 1 && b[0] == '0' {
+		return 0, true
+	}
+	p := b
+	if p[0] == '-' {
+		p = p[1:]
+	}
+	if len(p) == 0 || p[0] < '1' || p[0] > '9' {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	return n, err == nil
+}
+
+//string2ld parses b as a long double
+func string2ld(b []byte) (*big.Float, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	f, _, err := big.ParseFloat(string(b), 10, longDoublePrec, big.ToNearestEven)
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+//isLongDoubleOverflow reports whether f is out of the range of a long double
+func isLongDoubleOverflow(f *big.Float) bool {
+	return f.IsInf() || f.MantExp(nil) > 16384
+}
+
+//ld2string formats f like redis does for INCRBYFLOAT: 17 digits after the
+//point with the trailing zeros removed
+func ld2string(f *big.Float) string {
+	s := f.Text('f', 17)
+	if strings.IndexByte(s, '.') >= 0 {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+//string2d parses b as a double like redis does for sorted set scores, nan
+//and values out of range are rejected
+func string2d(b []byte) (float64, bool) {
+	if len(b) == 0 || b[0] == ' ' {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+//d2string formats a score the shortest way that reads back the same value,
+//with an exponent only when %.17g would use one
+func d2string(f float64) string {
+	if math.IsInf(f, 1) {
+		return "inf"
+	} else if math.IsInf(f, -1) {
+		return "-inf"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		if exp, _ := strconv.Atoi(s[i+1:]); exp >= -4 && exp < 17 {
+			s = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+	return s
+}
+
+//stringMatch reports whether str matches the glob-style pattern, supporting
+//the same syntax as redis: *, ?, [abc], [^abc], [a-z] and \ to escape
+func stringMatch(pattern, str []byte, nocase bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if stringMatch(pattern[1:], str[i:], nocase) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					c := str[0]
+					if nocase {
+						start, end, c = toLower(start), toLower(end), toLower(c)
+					}
+					pattern = pattern[2:]
+					if c >= start && c <= end {
+						match = true
+					}
+				} else if equalByte(pattern[0], str[0], nocase) {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				//missing ']', the redis way is to stop at the end of the pattern
+				return false
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || !equalByte(pattern[0], str[0], nocase) {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/vmihailenco/msgpack"
 	//"time"
 	"time"
+	"strconv"
 )
 
 var ents []structure.Entry
@@ -59,7 +60,8 @@ type Wal struct {
 	snapshotIndex uint64
 	snapcount     uint64
 	s             *Server
-	//mu sync.RWMutex
+	snapshotting  bool
+	mu            sync.Mutex
 }
 
 func (w *Wal) saveSnap(snap structure.SnapshotRecord) error {
@@ -99,15 +101,19 @@ func (w *Wal) replayWAL() {
 	}
 
 	if snapshot != nil {
-		err = w.s.db.recoverFromSnapshot(snapshot.Data)
+		err = w.s.recoverFromSnapshot(snapshot.Data)
 		if err != nil {
 			log.Fatalf("recoverFromSnapshot failed to read WAL (%v)", err)
 		}
 		w.s.w.snapshotIndex = snapshot.Index
 		w.s.w.nowIndex = snapshot.Index
 	}
-	//
-	w.s.db.recovebool = true
+	w.applyEntries(ents)
+}
+
+//applyEntries replays the wal entries written after the snapshot
+func (w *Wal) applyEntries(ents []structure.Entry) {
+	w.s.setRecovering(true)
 	if len(ents) > 0 {
 		for _, ent := range ents {
 			//fmt.Println(ent)
@@ -117,38 +123,150 @@ func (w *Wal) replayWAL() {
 				continue
 			}
 			//fmt.Println(dataKv)
+			db, err := w.s.database(dataKv.Db)
+			if err != nil {
+				log.Fatalf("raft-redis: wal entry %d for db %d (%v)", ent.Index, dataKv.Db, err)
+			}
 			switch  dataKv.Method {
 			case "rpush":
-				w.s.db.Rpush(dataKv.Args...)
+				db.Rpush(dataKv.Args...)
 			case "lpush":
-				w.s.db.Lpush(dataKv.Args...)
-			case "lpop":
-				w.s.db.Lpop(dataKv.Key)
-			case "rpop":
-				w.s.db.Rpop(dataKv.Key)
+				db.Lpush(dataKv.Args...)
+			case "lpop", "rpop":
+				count := 1
+				if len(dataKv.Args) > 0 {
+					count, _ = strconv.Atoi(string(dataKv.Args[0]))
+				}
+				db.Pop(dataKv.Key, dataKv.Method == "lpop", count)
+			case "lmove":
+				db.Lmove(dataKv.Key, string(dataKv.Args[0]), string(dataKv.Args[1]) == "left", string(dataKv.Args[2]) == "left")
+			case "lset":
+				index, _ := strconv.Atoi(string(dataKv.Args[0]))
+				db.Lset(dataKv.Key, index, dataKv.Args[1])
+			case "linsert":
+				db.Linsert(dataKv.Key, string(dataKv.Args[0]) == "after", dataKv.Args[1], dataKv.Args[2])
+			case "lrem":
+				count, _ := strconv.Atoi(string(dataKv.Args[0]))
+				db.Lrem(dataKv.Key, count, dataKv.Args[1])
+			case "ltrim":
+				start, _ := strconv.Atoi(string(dataKv.Args[0]))
+				stop, _ := strconv.Atoi(string(dataKv.Args[1]))
+				db.Ltrim(dataKv.Key, start, stop)
 			case "set":
-				w.s.db.Set(dataKv.Key, dataKv.Args[0])
+				flags, when := 0, int64(0)
+				for i := 1; i < len(dataKv.Args); i++ {
+					switch string(dataKv.Args[i]) {
+					case "keepttl":
+						flags |= setKeepTTL
+					case "pxat":
+						i++
+						when, _ = strconv.ParseInt(string(dataKv.Args[i]), 10, 64)
+					}
+				}
+				db.SetGeneric(dataKv.Key, dataKv.Args[0], flags, when)
 			case "hset":
-				w.s.db.Hset(dataKv.Key, string(dataKv.Args[0]), dataKv.Args[1])
+				db.Hset(dataKv.Key, dataKv.Args...)
+			case "hdel":
+				db.Hdel(dataKv.Key, dataKv.Args...)
+			case "hsetkeepttl":
+				db.hsetKeepTTL(dataKv.Key, string(dataKv.Args[0]), dataKv.Args[1])
+			case "hpexpireat":
+				when, _ := strconv.ParseInt(string(dataKv.Args[0]), 10, 64)
+				db.HpexpireAt(dataKv.Key, when, 0, dataKv.Args[1:])
+			case "hpersist":
+				db.Hpersist(dataKv.Key, dataKv.Args)
 			case "sadd":
-				w.s.db.Sadd(dataKv.Key, dataKv.Args...)
+				db.Sadd(dataKv.Key, dataKv.Args...)
 			case "del":
-				w.s.db.Del(dataKv.Args...)
+				db.Del(dataKv.Args...)
 			case "zadd":
-				key := dataKv.Args[0]
-				score := BytesToFloat(dataKv.Args[1])
-				w.s.db.Zadd(dataKv.Key, score, string(key))
+				var scores []float64
+				var members []string
+				for i := 0; i+1 < len(dataKv.Args); i += 2 {
+					members = append(members, string(dataKv.Args[i]))
+					scores = append(scores, BytesToFloat(dataKv.Args[i+1]))
+				}
+				db.Zadd(dataKv.Key, 0, scores, members)
+			case "zsetstore":
+				db.zsetStore(dataKv.Key, dataKv.Args)
+			case "zrem":
+				db.Zrem(dataKv.Key, dataKv.Args...)
 			case "incr":
-				w.s.db.Incr(dataKv.Key)
+				db.Incr(dataKv.Key)
 			case "mset":
-				w.s.db.Mset(dataKv.Args...)
+				db.Mset(dataKv.Args...)
+			case "srem":
+				db.Srem(dataKv.Key, dataKv.Args...)
+			case "smove":
+				db.Smove(dataKv.Key, string(dataKv.Args[0]), dataKv.Args[1])
+			case "setstore":
+				db.setStore(dataKv.Key, dataKv.Args)
 			case "spop":
-				w.s.db.spop(dataKv.Key, dataKv.Args[0])
+				db.spop(dataKv.Key, dataKv.Args[0])
+			case "pexpireat":
+				when, _ := strconv.ParseInt(string(dataKv.Args[0]), 10, 64)
+				db.PexpireAt(dataKv.Key, when)
+			case "persist":
+				db.Persist(dataKv.Key)
+			case "getset":
+				db.Getset(dataKv.Key, dataKv.Args[0])
+			case "getdel":
+				db.Getdel(dataKv.Key)
+			case "getex":
+				if string(dataKv.Args[0]) == "persist" {
+					db.Getex(dataKv.Key, 0, true)
+				} else {
+					when, _ := strconv.ParseInt(string(dataKv.Args[1]), 10, 64)
+					db.Getex(dataKv.Key, when, false)
+				}
+			case "setnx":
+				db.Setnx(dataKv.Key, dataKv.Args[0])
+			case "setex":
+				when, _ := strconv.ParseInt(string(dataKv.Args[1]), 10, 64)
+				db.Setex(dataKv.Key, dataKv.Args[0], when)
+			case "msetnx":
+				db.Msetnx(dataKv.Args...)
+			case "setrange":
+				offset, _ := strconv.ParseInt(string(dataKv.Args[0]), 10, 64)
+				db.Setrange(dataKv.Key, offset, dataKv.Args[1])
+			case "setbit":
+				offset, _ := strconv.ParseInt(string(dataKv.Args[0]), 10, 64)
+				db.Setbit(dataKv.Key, offset, string(dataKv.Args[1]) == "1")
+			case "bitop":
+				db.Bitop(string(dataKv.Args[0]), dataKv.Key, dataKv.Args[1:]...)
+			case "bitfield":
+				db.Bitfield(dataKv.Key, dataKv.Args, false)
+			case "pfadd":
+				db.Pfadd(dataKv.Key, dataKv.Args...)
+			case "pfmerge":
+				keys := make([]string, len(dataKv.Args))
+				for i, k := range dataKv.Args {
+					keys[i] = string(k)
+				}
+				db.Pfmerge(dataKv.Key, keys...)
+			case "append":
+				db.Append(dataKv.Key, dataKv.Args[0])
+			case "flushdb":
+				db.FlushDB(false)
+			case "flushall":
+				w.s.FlushAll(false)
+			case "move":
+				dst, _ := strconv.Atoi(string(dataKv.Args[0]))
+				w.s.Move(db, dataKv.Key, dst)
+			case "rename":
+				db.Rename(dataKv.Key, string(dataKv.Args[0]), false)
+			case "copy":
+				dst, _ := strconv.Atoi(string(dataKv.Args[1]))
+				w.s.Copy(db, dataKv.Key, string(dataKv.Args[0]), dst, true)
+			case "swapdb":
+				a, _ := strconv.Atoi(string(dataKv.Args[0]))
+				b, _ := strconv.Atoi(string(dataKv.Args[1]))
+				w.s.SwapDB(a, b)
 			}
 		}
 		w.s.w.nowIndex = ents[len(ents)-1].Index
 	}
-	w.s.db.recovebool = false
+	w.s.setRecovering(false)
 }
 
 func (n *Wal) loadSnapshot() *structure.SnapshotRecord {
@@ -160,6 +278,8 @@ func (n *Wal) loadSnapshot() *structure.SnapshotRecord {
 }
 
 func (wal *Wal) save(opt *Opt) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
 	switch wal.s.conf.walsavetype {
 	case "es": //every second
 		server := wal.s
@@ -182,13 +302,10 @@ func (wal *Wal) save(opt *Opt) error {
 			go wal.wal.SaveEntry(&es)
 		}
 
-		if server.w.nowIndex-wal.snapshotIndex >= server.w.snapcount {
-			data, err := wal.s.db.getSnapshot()
-			if err != nil {
-				return err
-			}
-			wal.saveSnap(structure.SnapshotRecord{Data: data, Index: server.w.nowIndex})
-			server.w.snapshotIndex = server.w.nowIndex
+		if server.w.nowIndex-wal.snapshotIndex >= server.w.snapcount && !wal.snapshotting {
+			//调用方持有某个db的写锁，快照需要所有db的读锁，所以放到后台去做
+			wal.snapshotting = true
+			go wal.snapshot()
 		}
 		server.w.nowIndex ++
 	default:
@@ -198,6 +315,29 @@ func (wal *Wal) save(opt *Opt) error {
 	return nil
 }
 
+//snapshot saves all the databases. Every entry up to nowIndex has been applied
+//once the read locks of all databases are held, because the callers of save
+//hold the write lock of their database until the operation is done.
+func (wal *Wal) snapshot() {
+	wal.s.rlockAll()
+	wal.mu.Lock()
+	index := wal.nowIndex
+	wal.mu.Unlock()
+	data, err := wal.s.getSnapshot()
+	wal.s.runlockAll()
+	if err == nil {
+		err = wal.saveSnap(structure.SnapshotRecord{Data: data, Index: index})
+	}
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+	wal.snapshotting = false
+	if err != nil {
+		log.Printf("raft-redis: failed to save snapshot (%v)", err)
+		return
+	}
+	wal.snapshotIndex = index
+}
+
 func InitNewWal(s *Server) {
 	s.w = &Wal{snapdir: s.conf.datadir + "snap/", waldir: s.conf.datadir + "wal/", snapcount: s.conf.snapCount}
 	s.w.s = s
@@ -217,11 +357,13 @@ func InitNewWal(s *Server) {
 	if s.conf.walsavetype == "es" {
 		go func() {
 			for {
+				s.w.mu.Lock()
 				if len(ents) > 0 {
 					entscopy := ents
 					ents = []structure.Entry{}
 					go s.w.wal.BatchSave(entscopy)
 				}
+				s.w.mu.Unlock()
 				time.Sleep(1 * time.Second)
 			}
 		}()